@@ -2,22 +2,40 @@
 package cloudwatch
 
 import (
+	"context"
 	"log"
+	"os"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 )
 
+//LogsAPI is the subset of the Cloudwatch logs API used by CW.
+//It is satisfied by *cloudwatchlogs.CloudWatchLogs, but any other backend (e.g. an in-memory or recorded one) can implement it.
+//Implementations are expected to abort in-flight requests once the given context is done.
+type LogsAPI interface {
+	DescribeLogGroupsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogGroupsInput, fn func(*cloudwatchlogs.DescribeLogGroupsOutput, bool) bool, opts ...request.Option) error
+	DescribeLogStreamsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogStreamsInput, fn func(*cloudwatchlogs.DescribeLogStreamsOutput, bool) bool, opts ...request.Option) error
+	FilterLogEventsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.FilterLogEventsInput, fn func(*cloudwatchlogs.FilterLogEventsOutput, bool) bool, opts ...request.Option) error
+	GetLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.GetLogEventsInput, opts ...request.Option) (*cloudwatchlogs.GetLogEventsOutput, error)
+}
+
+var _ LogsAPI = (*cloudwatchlogs.CloudWatchLogs)(nil)
+
 //CW provides the APIo peration methods for making requests to AWS cloudwatch logs.
 type CW struct {
-	awsClwClient *cloudwatchlogs.CloudWatchLogs
+	awsClwClient LogsAPI
 	log          *log.Logger
+	profile      string
+	region       string
 }
 
 // New creates a new instance of the CW client
-func New(awsProfile *string, awsRegion *string, log *log.Logger) *CW {
-	log.Printf("awsProfile: %s, awsRegion: %s\n", *awsProfile, *awsRegion)
+// A non empty awsEndpointURL overrides the default AWS endpoint, i.e. to target LocalStack or any compatible local service.
+func New(awsProfile *string, awsRegion *string, awsEndpointURL *string, log *log.Logger) *CW {
+	log.Printf("awsProfile: %s, awsRegion: %s, awsEndpointURL: %s\n", *awsProfile, *awsRegion, *awsEndpointURL)
 
 	opts := session.Options{
 		SharedConfigState: session.SharedConfigEnable,
@@ -28,10 +46,37 @@ func New(awsProfile *string, awsRegion *string, log *log.Logger) *CW {
 	}
 
 	if awsRegion != nil {
-		opts.Config = aws.Config{Region: awsRegion}
+		opts.Config.Region = awsRegion
+	}
+
+	if awsEndpointURL != nil && *awsEndpointURL != "" {
+		opts.Config.Endpoint = awsEndpointURL
 	}
 
 	sess := session.Must(session.NewSessionWithOptions(opts))
-	return &CW{awsClwClient: cloudwatchlogs.New(sess),
+	cw := NewWithClient(cloudwatchlogs.New(sess), log)
+	cw.profile = opts.Profile
+	if cw.profile == "" {
+		cw.profile = "default"
+		if p := os.Getenv("AWS_PROFILE"); p != "" {
+			cw.profile = p
+		}
+	}
+	cw.region = aws.StringValue(sess.Config.Region)
+	return cw
+}
+
+// NewWithClient creates a new instance of the CW client on top of the given LogsAPI implementation
+func NewWithClient(client LogsAPI, log *log.Logger) *CW {
+	return &CW{awsClwClient: client,
 		log: log}
 }
+
+//contextError returns the context error once the context is done, err otherwise.
+//AWS requests aborted by a cancellation are reported as the plain context error.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
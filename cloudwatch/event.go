@@ -0,0 +1,74 @@
+package cloudwatch
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+//Event is a log event published by the tailing APIs
+type Event struct {
+	//Group is the name of the log group the event belongs to.
+	Group string
+	//Stream is the name of the log stream the event belongs to.
+	Stream string
+	//EventID is the unique identifier of the event. It is empty for the events returned by EventContext.
+	EventID string
+	//Time is the time of the event, in UTC.
+	Time time.Time
+	//IngestionTime is the time the event was ingested by Cloudwatch, in UTC.
+	IngestionTime time.Time
+	//Message is the event message.
+	Message string
+	//Profile is the AWS profile the event was fetched with. It is empty for clients not created by New.
+	Profile string
+	//Region is the AWS region the event was fetched from. It is empty for clients not created by New.
+	Region string
+	//Label is the TailOptions.Label of the tail that published the event, if any.
+	Label string
+	//Filtered is true when the tail that published the event applied a filter pattern or client side filters.
+	Filtered bool
+}
+
+func (cwl *CW) newEvent(group string, ev *cloudwatchlogs.FilteredLogEvent) *Event {
+	e := &Event{Group: group,
+		Profile: cwl.profile,
+		Region:  cwl.region}
+	if ev.LogStreamName != nil {
+		e.Stream = *ev.LogStreamName
+	}
+	if ev.EventId != nil {
+		e.EventID = *ev.EventId
+	}
+	if ev.Timestamp != nil {
+		e.Time = millisToTime(*ev.Timestamp)
+	}
+	if ev.IngestionTime != nil {
+		e.IngestionTime = millisToTime(*ev.IngestionTime)
+	}
+	if ev.Message != nil {
+		e.Message = *ev.Message
+	}
+	return e
+}
+
+func (cwl *CW) newStreamEvent(group string, stream string, ev *cloudwatchlogs.OutputLogEvent) *Event {
+	e := &Event{Group: group,
+		Stream:  stream,
+		Profile: cwl.profile,
+		Region:  cwl.region}
+	if ev.Timestamp != nil {
+		e.Time = millisToTime(*ev.Timestamp)
+	}
+	if ev.IngestionTime != nil {
+		e.IngestionTime = millisToTime(*ev.IngestionTime)
+	}
+	if ev.Message != nil {
+		e.Message = *ev.Message
+	}
+	return e
+}
+
+func millisToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
@@ -0,0 +1,119 @@
+package cloudwatch
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+)
+
+//cacheStats counts what happened to the IDs of an eventCache.
+type cacheStats struct {
+	added      int
+	duplicates int
+	expired    int
+	evicted    int
+}
+
+func (s cacheStats) String() string {
+	return fmt.Sprintf("added:%d duplicates:%d expired:%d evicted:%d", s.added, s.duplicates, s.expired, s.evicted)
+}
+
+//timestamps is a min heap of event timestamps.
+type timestamps []int64
+
+func (t timestamps) Len() int            { return len(t) }
+func (t timestamps) Less(i, j int) bool  { return t[i] < t[j] }
+func (t timestamps) Swap(i, j int)       { t[i], t[j] = t[j], t[i] }
+func (t *timestamps) Push(x interface{}) { *t = append(*t, x.(int64)) }
+func (t *timestamps) Pop() interface{} {
+	old := *t
+	ts := old[len(old)-1]
+	*t = old[:len(old)-1]
+	return ts
+}
+
+//eventCache remembers the IDs of the published events to discard the ones returned again by the following polls.
+//IDs are kept by event timestamp rather than for a wall clock time: once polls start after the timestamp of an event,
+//it can't be returned again and its ID expires. At most maxSize IDs are kept, the ones with the oldest timestamps are evicted first.
+type eventCache struct {
+	ids        map[string]int64
+	buckets    map[int64][]string
+	timestamps timestamps
+	maxSize    int
+	stats      cacheStats
+	sync.Mutex
+}
+
+//newEventCache creates a cache holding at most maxSize IDs.
+func newEventCache(maxSize int) *eventCache {
+	return &eventCache{ids: make(map[string]int64),
+		buckets: make(map[int64][]string),
+		maxSize: maxSize}
+}
+
+//Has returns true if the ID has already been added; it is counted as a duplicate.
+func (c *eventCache) Has(eventID string) bool {
+	c.Lock()
+	defer c.Unlock()
+	_, ok := c.ids[eventID]
+	if ok {
+		c.stats.duplicates++
+	}
+	return ok
+}
+
+//Add adds the ID of an event with the given timestamp, evicting the oldest IDs beyond the size limit.
+func (c *eventCache) Add(eventID string, ts int64) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.ids[eventID]; ok {
+		return
+	}
+	c.ids[eventID] = ts
+	if _, ok := c.buckets[ts]; !ok {
+		heap.Push(&c.timestamps, ts)
+	}
+	c.buckets[ts] = append(c.buckets[ts], eventID)
+	c.stats.added++
+
+	for len(c.ids) > c.maxSize {
+		oldest := c.timestamps[0]
+		bucket := c.buckets[oldest]
+		delete(c.ids, bucket[0])
+		c.stats.evicted++
+		if len(bucket) == 1 {
+			delete(c.buckets, oldest)
+			heap.Pop(&c.timestamps)
+		} else {
+			c.buckets[oldest] = bucket[1:]
+		}
+	}
+}
+
+//Expire removes the IDs of the events older than the given timestamp: polls starting from it won't return them.
+func (c *eventCache) Expire(before int64) {
+	c.Lock()
+	defer c.Unlock()
+	for len(c.timestamps) > 0 && c.timestamps[0] < before {
+		oldest := heap.Pop(&c.timestamps).(int64)
+		for _, id := range c.buckets[oldest] {
+			delete(c.ids, id)
+			c.stats.expired++
+		}
+		delete(c.buckets, oldest)
+	}
+}
+
+//Size returns the number of IDs in the cache.
+func (c *eventCache) Size() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.ids)
+}
+
+//Stats returns the counters of the cache.
+func (c *eventCache) Stats() cacheStats {
+	c.Lock()
+	defer c.Unlock()
+	return c.stats
+}
@@ -0,0 +1,118 @@
+package cloudwatch
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+//maxContextPages bounds the GetLogEvents requests issued to read the context in each direction.
+const maxContextPages = 10
+
+//EventContext returns up to before events preceding and up to after events following the given event in its log stream,
+//regardless of any filter: the equivalent of grep --before-context and --after-context.
+//Both slices are sorted by time. GetLogEvents doesn't return event IDs, the event is located in its stream by timestamp and message;
+//empty slices are returned when it can't be found.
+//If limiter is not nil every GetLogEvents request waits for a value from it, like the polls of Tail do;
+//once it is closed no more requests are issued.
+func (cwl *CW) EventContext(ev *Event, before int, after int, limiter <-chan time.Time) ([]*Event, []*Event, error) {
+	return cwl.EventContextWithContext(context.Background(), ev, before, after, limiter)
+}
+
+//EventContextWithContext is the same as EventContext with the addition of the ability to pass a context.
+func (cwl *CW) EventContextWithContext(ctx context.Context, ev *Event, before int, after int, limiter <-chan time.Time) ([]*Event, []*Event, error) {
+	var preceding, following []*Event
+	var err error
+	if before > 0 {
+		if preceding, err = cwl.surroundingEvents(ctx, ev, before, false, limiter); err != nil {
+			return nil, nil, err
+		}
+	}
+	if after > 0 {
+		if following, err = cwl.surroundingEvents(ctx, ev, after, true, limiter); err != nil {
+			return nil, nil, err
+		}
+	}
+	return preceding, following, nil
+}
+
+//surroundingEvents reads ev's stream from ev's timestamp onwards, or backwards, and returns up to n events following,
+//or preceding, ev itself, sorted by time.
+func (cwl *CW) surroundingEvents(ctx context.Context, ev *Event, n int, forward bool, limiter <-chan time.Time) ([]*Event, error) {
+	ts := ev.Time.UnixNano() / int64(time.Millisecond)
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(ev.Group),
+		LogStreamName: aws.String(ev.Stream),
+		StartFromHead: aws.Bool(forward),
+		Limit:         aws.Int64(int64(n + 1))}
+	if forward {
+		input.StartTime = aws.Int64(ts)
+	} else {
+		input.EndTime = aws.Int64(ts + 1)
+	}
+
+	var events []*Event //in reading order
+	found := -1
+	for page := 0; page < maxContextPages; page++ {
+		if limiter != nil {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case _, ok := <-limiter:
+				if !ok {
+					return nil, nil
+				}
+			}
+		}
+		res, err := cwl.awsClwClient.GetLogEventsWithContext(ctx, input)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		for i := range res.Events {
+			e := res.Events[i]
+			if !forward {
+				e = res.Events[len(res.Events)-1-i]
+			}
+			event := cwl.newStreamEvent(ev.Group, ev.Stream, e)
+			event.Label = ev.Label
+			if found < 0 {
+				if !event.Time.Equal(ev.Time) { //past ev's timestamp without finding it
+					return nil, nil
+				}
+				if event.Message == ev.Message {
+					found = len(events)
+				}
+			}
+			events = append(events, event)
+		}
+		if found >= 0 && len(events)-found-1 >= n {
+			break
+		}
+
+		next := res.NextForwardToken
+		if !forward {
+			next = res.NextBackwardToken
+		}
+		//pages can be empty before the end of the stream, which is reached once the token comes back unchanged
+		if next == nil || (input.NextToken != nil && *next == *input.NextToken) {
+			break
+		}
+		input.NextToken = next
+	}
+	if found < 0 {
+		return nil, nil
+	}
+
+	events = events[found+1:]
+	if len(events) > n {
+		events = events[:n]
+	}
+	if !forward {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+	}
+	return events, nil
+}
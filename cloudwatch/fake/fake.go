@@ -0,0 +1,623 @@
+// Package fake provides an in-memory Cloudwatch logs backend implementing cloudwatch.LogsAPI
+package fake
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// ErrCodeThrottlingException is the error code returned by throttled calls
+const ErrCodeThrottlingException = "ThrottlingException"
+
+const (
+	defaultGroupsPageSize  = 50
+	defaultStreamsPageSize = 50
+	defaultEventsPageSize  = 10000
+	maxFilterStreams       = 100
+)
+
+type event struct {
+	id            string
+	stream        string
+	timestamp     int64
+	ingestionTime int64
+	message       string
+}
+
+type logStream struct {
+	name              string
+	creationTime      int64
+	firstEventTime    int64
+	lastEventTime     int64
+	lastIngestionTime int64
+	events            []*event
+}
+
+type logGroup struct {
+	name         string
+	creationTime int64
+	streams      map[string]*logStream
+}
+
+// Logs is an in-memory log store.
+// Events are only returned by FilterLogEvents once their ingestion time plus the configured visibility delay has elapsed,
+// emulating the eventual consistency of the real service.
+type Logs struct {
+	groups    map[string]*logGroup
+	now       func() time.Time
+	delay     time.Duration
+	failures  []error
+	calls     map[string]int
+	nextID    int64
+	pageSizes map[string]int
+	emptySkip int
+	empty     int
+	emptySeq  int
+	sync.Mutex
+}
+
+// New creates an empty in-memory log store using the wall clock
+func New() *Logs {
+	return &Logs{groups: make(map[string]*logGroup),
+		now:       time.Now,
+		calls:     make(map[string]int),
+		pageSizes: make(map[string]int)}
+}
+
+// SetClock replaces the clock used for ingestion times and visibility checks
+func (l *Logs) SetClock(now func() time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	l.now = now
+}
+
+// SetVisibilityDelay sets how long after ingestion an event becomes visible to FilterLogEvents
+func (l *Logs) SetVisibilityDelay(d time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	l.delay = d
+}
+
+// SetPageSize sets the maximum number of items returned per page by the given operation
+// (DescribeLogGroups, DescribeLogStreams, FilterLogEvents or GetLogEvents)
+func (l *Logs) SetPageSize(operation string, size int) {
+	l.Lock()
+	defer l.Unlock()
+	l.pageSizes[operation] = size
+}
+
+// EmptyPages makes the n GetLogEvents calls following the next skip ones return no events,
+// with tokens resuming where they would have read: the service does so when a page scans a time range without events.
+func (l *Logs) EmptyPages(skip int, n int) {
+	l.Lock()
+	defer l.Unlock()
+	l.emptySkip = skip
+	l.empty = n
+}
+
+// Throttle makes the next n calls fail with a ThrottlingException
+func (l *Logs) Throttle(n int) {
+	for i := 0; i < n; i++ {
+		l.Fail(awserr.New(ErrCodeThrottlingException, "Rate exceeded", nil))
+	}
+}
+
+// Fail makes the next call fail with the given error. Failures are queued and consumed one per call,
+// a nil error lets the corresponding call succeed.
+func (l *Logs) Fail(err error) {
+	l.Lock()
+	defer l.Unlock()
+	l.failures = append(l.failures, err)
+}
+
+// Calls returns the number of requests (pages) served for the given operation, failed ones included
+func (l *Logs) Calls(operation string) int {
+	l.Lock()
+	defer l.Unlock()
+	return l.calls[operation]
+}
+
+// CreateLogGroup creates a log group, if it doesn't exist yet
+func (l *Logs) CreateLogGroup(name string) {
+	l.Lock()
+	defer l.Unlock()
+	l.createLogGroup(name)
+}
+
+func (l *Logs) createLogGroup(name string) *logGroup {
+	if g, ok := l.groups[name]; ok {
+		return g
+	}
+	g := &logGroup{name: name,
+		creationTime: toMillis(l.now()),
+		streams:      make(map[string]*logStream)}
+	l.groups[name] = g
+	return g
+}
+
+// CreateLogStream creates a log stream, and its group if needed
+func (l *Logs) CreateLogStream(group string, stream string) {
+	l.Lock()
+	defer l.Unlock()
+	l.createLogStream(group, stream)
+}
+
+func (l *Logs) createLogStream(group string, stream string) *logStream {
+	g := l.createLogGroup(group)
+	if s, ok := g.streams[stream]; ok {
+		return s
+	}
+	s := &logStream{name: stream, creationTime: toMillis(l.now())}
+	g.streams[stream] = s
+	return s
+}
+
+// PutLogEvent stores an event with the given timestamp and the current clock as ingestion time.
+// Group and stream are created if needed. It returns the generated event ID.
+func (l *Logs) PutLogEvent(group string, stream string, timestamp time.Time, message string) string {
+	l.Lock()
+	defer l.Unlock()
+	return l.putLogEvent(group, stream, toMillis(timestamp), toMillis(l.now()), message)
+}
+
+// PutLogEventIngestedAt stores an event with explicit timestamp and ingestion time.
+// It returns the generated event ID.
+func (l *Logs) PutLogEventIngestedAt(group string, stream string, timestamp time.Time, ingestionTime time.Time, message string) string {
+	l.Lock()
+	defer l.Unlock()
+	return l.putLogEvent(group, stream, toMillis(timestamp), toMillis(ingestionTime), message)
+}
+
+func (l *Logs) putLogEvent(group string, stream string, timestamp int64, ingestionTime int64, message string) string {
+	s := l.createLogStream(group, stream)
+	l.nextID++
+	ev := &event{id: fmt.Sprintf("%056d", l.nextID),
+		stream:        stream,
+		timestamp:     timestamp,
+		ingestionTime: ingestionTime,
+		message:       message}
+	s.events = append(s.events, ev)
+	sort.SliceStable(s.events, func(i, j int) bool {
+		return s.events[i].timestamp < s.events[j].timestamp
+	})
+	s.firstEventTime = s.events[0].timestamp
+	s.lastEventTime = s.events[len(s.events)-1].timestamp
+	if ingestionTime > s.lastIngestionTime {
+		s.lastIngestionTime = ingestionTime
+	}
+	return ev.id
+}
+
+// DescribeLogGroups returns a page of log groups sorted by name
+func (l *Logs) DescribeLogGroups(input *cloudwatchlogs.DescribeLogGroupsInput) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	l.Lock()
+	defer l.Unlock()
+	if err := l.call("DescribeLogGroups"); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range l.groups {
+		if input.LogGroupNamePrefix == nil || strings.HasPrefix(name, *input.LogGroupNamePrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	from, to, next, err := l.page("DescribeLogGroups", len(names), input.NextToken, input.Limit, defaultGroupsPageSize)
+	if err != nil {
+		return nil, err
+	}
+	out := &cloudwatchlogs.DescribeLogGroupsOutput{NextToken: next}
+	for _, name := range names[from:to] {
+		g := l.groups[name]
+		out.LogGroups = append(out.LogGroups, &cloudwatchlogs.LogGroup{
+			LogGroupName: aws.String(g.name),
+			Arn:          aws.String("arn:aws:logs:fake:000000000000:log-group:" + g.name + ":*"),
+			CreationTime: aws.Int64(g.creationTime)})
+	}
+	return out, nil
+}
+
+// DescribeLogGroupsPagesWithContext iterates over the pages of a DescribeLogGroups operation until the context is done
+func (l *Logs) DescribeLogGroupsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogGroupsInput, fn func(*cloudwatchlogs.DescribeLogGroupsOutput, bool) bool, opts ...request.Option) error {
+	in := *input
+	for {
+		if err := canceled(ctx); err != nil {
+			return err
+		}
+		out, err := l.DescribeLogGroups(&in)
+		if err != nil {
+			return err
+		}
+		lastPage := out.NextToken == nil
+		if !fn(out, lastPage) || lastPage {
+			return nil
+		}
+		in.NextToken = out.NextToken
+	}
+}
+
+// DescribeLogStreams returns a page of log streams of the given group
+func (l *Logs) DescribeLogStreams(input *cloudwatchlogs.DescribeLogStreamsInput) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	l.Lock()
+	defer l.Unlock()
+	if err := l.call("DescribeLogStreams"); err != nil {
+		return nil, err
+	}
+
+	g, err := l.group(input.LogGroupName)
+	if err != nil {
+		return nil, err
+	}
+	orderByLastEventTime := input.OrderBy != nil && *input.OrderBy == cloudwatchlogs.OrderByLastEventTime
+	if orderByLastEventTime && input.LogStreamNamePrefix != nil {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException,
+			"Cannot order by LastEventTime with a logStreamNamePrefix.", nil)
+	}
+
+	var streams []*logStream
+	for name, s := range g.streams {
+		if input.LogStreamNamePrefix == nil || strings.HasPrefix(name, *input.LogStreamNamePrefix) {
+			streams = append(streams, s)
+		}
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		if orderByLastEventTime && streams[i].lastEventTime != streams[j].lastEventTime {
+			return streams[i].lastEventTime < streams[j].lastEventTime
+		}
+		return streams[i].name < streams[j].name
+	})
+	if input.Descending != nil && *input.Descending {
+		for i, j := 0, len(streams)-1; i < j; i, j = i+1, j-1 {
+			streams[i], streams[j] = streams[j], streams[i]
+		}
+	}
+
+	from, to, next, err := l.page("DescribeLogStreams", len(streams), input.NextToken, input.Limit, defaultStreamsPageSize)
+	if err != nil {
+		return nil, err
+	}
+	out := &cloudwatchlogs.DescribeLogStreamsOutput{NextToken: next}
+	for _, s := range streams[from:to] {
+		ls := &cloudwatchlogs.LogStream{
+			LogStreamName: aws.String(s.name),
+			CreationTime:  aws.Int64(s.creationTime)}
+		if len(s.events) > 0 { //like the service, the event fields are left out for streams without events
+			ls.LastIngestionTime = aws.Int64(s.lastIngestionTime)
+			ls.FirstEventTimestamp = aws.Int64(s.firstEventTime)
+			ls.LastEventTimestamp = aws.Int64(s.lastEventTime)
+		}
+		out.LogStreams = append(out.LogStreams, ls)
+	}
+	return out, nil
+}
+
+// DescribeLogStreamsPagesWithContext iterates over the pages of a DescribeLogStreams operation until the context is done
+func (l *Logs) DescribeLogStreamsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogStreamsInput, fn func(*cloudwatchlogs.DescribeLogStreamsOutput, bool) bool, opts ...request.Option) error {
+	in := *input
+	for {
+		if err := canceled(ctx); err != nil {
+			return err
+		}
+		out, err := l.DescribeLogStreams(&in)
+		if err != nil {
+			return err
+		}
+		lastPage := out.NextToken == nil
+		if !fn(out, lastPage) || lastPage {
+			return nil
+		}
+		in.NextToken = out.NextToken
+	}
+}
+
+// FilterLogEvents returns a page of the visible events of a group, interleaved across streams and sorted by timestamp.
+// Filter patterns are supported as a list of terms that must all appear in the message.
+func (l *Logs) FilterLogEvents(input *cloudwatchlogs.FilterLogEventsInput) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	l.Lock()
+	defer l.Unlock()
+	if err := l.call("FilterLogEvents"); err != nil {
+		return nil, err
+	}
+
+	g, err := l.group(input.LogGroupName)
+	if err != nil {
+		return nil, err
+	}
+	if len(input.LogStreamNames) > maxFilterStreams {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException,
+			fmt.Sprintf("1 validation error detected: Value at 'logStreamNames' failed to satisfy constraint: Member must have length less than or equal to %d", maxFilterStreams), nil)
+	}
+
+	var streams []*logStream
+	if len(input.LogStreamNames) > 0 {
+		for _, name := range input.LogStreamNames {
+			s, ok := g.streams[*name]
+			if !ok {
+				return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log stream does not exist.", nil)
+			}
+			streams = append(streams, s)
+		}
+	} else {
+		for _, s := range g.streams {
+			streams = append(streams, s)
+		}
+	}
+
+	var terms []string
+	if input.FilterPattern != nil {
+		terms = patternTerms(*input.FilterPattern)
+	}
+	visibleUntil := toMillis(l.now().Add(-l.delay))
+
+	var events []*event
+	for _, s := range streams {
+		for _, ev := range s.events {
+			if ev.ingestionTime > visibleUntil {
+				continue
+			}
+			if input.StartTime != nil && ev.timestamp < *input.StartTime {
+				continue
+			}
+			if input.EndTime != nil && ev.timestamp > *input.EndTime {
+				continue
+			}
+			if !matches(ev.message, terms) {
+				continue
+			}
+			events = append(events, ev)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].timestamp != events[j].timestamp {
+			return events[i].timestamp < events[j].timestamp
+		}
+		return events[i].id < events[j].id
+	})
+
+	from, to, next, err := l.page("FilterLogEvents", len(events), input.NextToken, input.Limit, defaultEventsPageSize)
+	if err != nil {
+		return nil, err
+	}
+	out := &cloudwatchlogs.FilterLogEventsOutput{NextToken: next}
+	for _, ev := range events[from:to] {
+		out.Events = append(out.Events, &cloudwatchlogs.FilteredLogEvent{
+			EventId:       aws.String(ev.id),
+			LogStreamName: aws.String(ev.stream),
+			Timestamp:     aws.Int64(ev.timestamp),
+			IngestionTime: aws.Int64(ev.ingestionTime),
+			Message:       aws.String(ev.message)})
+	}
+	for _, s := range streams {
+		out.SearchedLogStreams = append(out.SearchedLogStreams, &cloudwatchlogs.SearchedLogStream{
+			LogStreamName:      aws.String(s.name),
+			SearchedCompletely: aws.Bool(next == nil)})
+	}
+	return out, nil
+}
+
+// FilterLogEventsPagesWithContext iterates over the pages of a FilterLogEvents operation until the context is done
+func (l *Logs) FilterLogEventsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.FilterLogEventsInput, fn func(*cloudwatchlogs.FilterLogEventsOutput, bool) bool, opts ...request.Option) error {
+	in := *input
+	for {
+		if err := canceled(ctx); err != nil {
+			return err
+		}
+		out, err := l.FilterLogEvents(&in)
+		if err != nil {
+			return err
+		}
+		lastPage := out.NextToken == nil
+		if !fn(out, lastPage) || lastPage {
+			return nil
+		}
+		in.NextToken = out.NextToken
+	}
+}
+
+// GetLogEvents returns a page of the visible events of a stream, sorted by timestamp.
+// Without a token it reads from the head of the stream when StartFromHead is true, from its tail otherwise.
+// Like the real service it returns the token it was passed once the end of the stream is reached in that direction.
+func (l *Logs) GetLogEvents(input *cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	l.Lock()
+	defer l.Unlock()
+	if err := l.call("GetLogEvents"); err != nil {
+		return nil, err
+	}
+
+	g, err := l.group(input.LogGroupName)
+	if err != nil {
+		return nil, err
+	}
+	if input.LogStreamName == nil {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "logStreamName is required.", nil)
+	}
+	s, ok := g.streams[*input.LogStreamName]
+	if !ok {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log stream does not exist.", nil)
+	}
+
+	visibleUntil := toMillis(l.now().Add(-l.delay))
+	var events []*event
+	for _, ev := range s.events {
+		if ev.ingestionTime > visibleUntil {
+			continue
+		}
+		if input.StartTime != nil && ev.timestamp < *input.StartTime {
+			continue
+		}
+		if input.EndTime != nil && ev.timestamp >= *input.EndTime {
+			continue
+		}
+		events = append(events, ev)
+	}
+
+	size := defaultEventsPageSize
+	if ps, ok := l.pageSizes["GetLogEvents"]; ok && ps > 0 {
+		size = ps
+	}
+	if input.Limit != nil && int(*input.Limit) < size {
+		size = int(*input.Limit)
+	}
+
+	forward := input.StartFromHead != nil && *input.StartFromHead
+	offset := -1
+	if input.NextToken != nil {
+		tok := *input.NextToken
+		if len(tok) > 2 && (tok[:2] == "f/" || tok[:2] == "b/") {
+			offset, err = strconv.Atoi(strings.SplitN(tok[2:], "/", 2)[0]) //empty pages tokens carry a sequence number
+			forward = tok[0] == 'f'
+		}
+		if offset < 0 || err != nil {
+			return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "The specified nextToken is invalid.", nil)
+		}
+	}
+
+	var from, to int
+	if forward {
+		if offset >= 0 {
+			from = offset
+		}
+		if from > len(events) {
+			from = len(events)
+		}
+		to = from + size
+		if to > len(events) {
+			to = len(events)
+		}
+	} else {
+		to = len(events)
+		if offset >= 0 && offset < to {
+			to = offset
+		}
+		from = to - size
+		if from < 0 {
+			from = 0
+		}
+	}
+
+	if l.emptySkip > 0 {
+		l.emptySkip--
+	} else if l.empty > 0 {
+		l.empty--
+		l.emptySeq++
+		resume := to
+		if forward {
+			resume = from
+		}
+		seq := "/" + strconv.Itoa(l.emptySeq)
+		return &cloudwatchlogs.GetLogEventsOutput{
+			NextForwardToken:  aws.String("f/" + strconv.Itoa(resume) + seq),
+			NextBackwardToken: aws.String("b/" + strconv.Itoa(resume) + seq)}, nil
+	}
+
+	out := &cloudwatchlogs.GetLogEventsOutput{
+		NextForwardToken:  aws.String("f/" + strconv.Itoa(to)),
+		NextBackwardToken: aws.String("b/" + strconv.Itoa(from))}
+	for _, ev := range events[from:to] {
+		out.Events = append(out.Events, &cloudwatchlogs.OutputLogEvent{
+			Timestamp:     aws.Int64(ev.timestamp),
+			IngestionTime: aws.Int64(ev.ingestionTime),
+			Message:       aws.String(ev.message)})
+	}
+	return out, nil
+}
+
+// GetLogEventsWithContext is the same as GetLogEvents, failing once the context is done
+func (l *Logs) GetLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.GetLogEventsInput, opts ...request.Option) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return l.GetLogEvents(input)
+}
+
+// call records a request for the operation and returns the next queued failure, if any
+func (l *Logs) call(operation string) error {
+	l.calls[operation]++
+	if len(l.failures) == 0 {
+		return nil
+	}
+	err := l.failures[0]
+	l.failures = l.failures[1:]
+	return err
+}
+
+func canceled(ctx aws.Context) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	return nil
+}
+
+func (l *Logs) group(name *string) (*logGroup, error) {
+	if name == nil {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "logGroupName is required.", nil)
+	}
+	g, ok := l.groups[*name]
+	if !ok {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log group does not exist.", nil)
+	}
+	return g, nil
+}
+
+// page computes the bounds of the requested page over total items. Tokens are plain offsets.
+func (l *Logs) page(operation string, total int, token *string, limit *int64, defaultSize int) (int, int, *string, error) {
+	size := defaultSize
+	if s, ok := l.pageSizes[operation]; ok && s > 0 {
+		size = s
+	}
+	if limit != nil && int(*limit) < size {
+		size = int(*limit)
+	}
+
+	from := 0
+	if token != nil {
+		offset, err := strconv.Atoi(*token)
+		if err != nil || offset < 0 {
+			return 0, 0, nil, awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "The specified nextToken is invalid.", nil)
+		}
+		from = offset
+	}
+	if from > total {
+		from = total
+	}
+	to := from + size
+	if to >= total {
+		return from, total, nil, nil
+	}
+	return from, to, aws.String(strconv.Itoa(to)), nil
+}
+
+func patternTerms(pattern string) []string {
+	var terms []string
+	for _, t := range strings.Fields(pattern) {
+		t = strings.Trim(t, `"`)
+		if t != "" {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+func matches(message string, terms []string) bool {
+	for _, t := range terms {
+		if !strings.Contains(message, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
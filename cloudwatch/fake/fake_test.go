@@ -0,0 +1,79 @@
+package fake_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/lucagrulla/cw/cloudwatch"
+	"github.com/lucagrulla/cw/cloudwatch/fake"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ cloudwatch.LogsAPI = fake.New()
+
+func TestDescribeLogStreamsPagination(t *testing.T) {
+	a := assert.New(t)
+	logs := fake.New()
+	logs.SetPageSize("DescribeLogStreams", 2)
+	for _, s := range []string{"web-1", "web-2", "web-3", "worker-1"} {
+		logs.CreateLogStream("group", s)
+	}
+	logs.PutLogEvent("group", "web-2", time.Now(), "msg")
+
+	var names []string
+	ingested := map[string]bool{}
+	pages := 0
+	err := logs.DescribeLogStreamsPagesWithContext(context.Background(), &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        aws.String("group"),
+		LogStreamNamePrefix: aws.String("web-")},
+		func(out *cloudwatchlogs.DescribeLogStreamsOutput, lastPage bool) bool {
+			pages++
+			for _, s := range out.LogStreams {
+				names = append(names, *s.LogStreamName)
+				ingested[*s.LogStreamName] = s.LastIngestionTime != nil
+			}
+			return !lastPage
+		})
+
+	a.NoError(err)
+	a.Equal(2, pages)
+	a.Equal([]string{"web-1", "web-2", "web-3"}, names)
+	a.Equal(map[string]bool{"web-1": false, "web-2": true, "web-3": false}, ingested, "empty streams have no LastIngestionTime")
+}
+
+func TestFilterLogEventsVisibilityAndThrottling(t *testing.T) {
+	a := assert.New(t)
+	now := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+	logs := fake.New()
+	logs.SetClock(func() time.Time { return now })
+	logs.SetVisibilityDelay(2 * time.Second)
+
+	logs.PutLogEvent("group", "stream", now.Add(-time.Second), "ERROR boom")
+	logs.PutLogEventIngestedAt("group", "stream", now.Add(-time.Minute), now.Add(-time.Minute), "INFO ok")
+
+	input := &cloudwatchlogs.FilterLogEventsInput{LogGroupName: aws.String("group")}
+	out, err := logs.FilterLogEvents(input)
+	a.NoError(err)
+	a.Len(out.Events, 1, "events ingested less than the visibility delay ago must be hidden")
+
+	now = now.Add(3 * time.Second)
+	input.FilterPattern = aws.String("ERROR")
+	out, err = logs.FilterLogEvents(input)
+	a.NoError(err)
+	if a.Len(out.Events, 1) {
+		a.Equal("ERROR boom", *out.Events[0].Message)
+	}
+
+	logs.Throttle(1)
+	_, err = logs.FilterLogEvents(input)
+	if awsErr, ok := err.(awserr.Error); a.True(ok) {
+		a.Equal(fake.ErrCodeThrottlingException, awsErr.Code())
+	}
+	_, err = logs.FilterLogEvents(input)
+	a.NoError(err)
+	a.Equal(4, logs.Calls("FilterLogEvents"))
+}
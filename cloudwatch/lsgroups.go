@@ -1,38 +1,46 @@
 package cloudwatch
 
 import (
-	"fmt"
-	"os"
+	"context"
 
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 )
 
-//LsGroups lists the stream groups
-//It returns a channel where stream groups are published
-func (cwl *CW) LsGroups() <-chan *string {
+//LsGroups lists the stream groups, only the ones whose name starts with groupNamePrefix if it isn't nil.
+//It returns a channel where stream groups are published and a channel where an eventual error is published.
+//The error channel is closed after the groups channel.
+func (cwl *CW) LsGroups(groupNamePrefix *string) (<-chan *string, <-chan error) {
+	return cwl.LsGroupsWithContext(context.Background(), groupNamePrefix)
+}
+
+//LsGroupsWithContext is the same as LsGroups with the addition of the ability to pass a context.
+//Once the context is done the listing stops, both channels are closed and the context error is published.
+func (cwl *CW) LsGroupsWithContext(ctx context.Context, groupNamePrefix *string) (<-chan *string, <-chan error) {
 	ch := make(chan *string)
+	errc := make(chan error, 1)
 	params := &cloudwatchlogs.DescribeLogGroupsInput{}
+	if groupNamePrefix != nil && *groupNamePrefix != "" {
+		params.LogGroupNamePrefix = groupNamePrefix
+	}
 
 	handler := func(res *cloudwatchlogs.DescribeLogGroupsOutput, lastPage bool) bool {
 		for _, logGroup := range res.LogGroups {
-			ch <- logGroup.LogGroupName
-		}
-		if lastPage {
-			close(ch)
+			select {
+			case ch <- logGroup.LogGroupName:
+			case <-ctx.Done():
+				return false
+			}
 		}
 		return !lastPage
 	}
 
 	go func() {
-		err := cwl.awsClwClient.DescribeLogGroupsPages(params, handler)
-		if err != nil {
-			if awsErr, ok := err.(awserr.Error); ok {
-				fmt.Fprintln(os.Stderr, awsErr.Message())
-				os.Exit(1)
-				close(ch)
-			}
+		defer close(errc)
+		defer close(ch)
+		err := cwl.awsClwClient.DescribeLogGroupsPagesWithContext(ctx, params, handler)
+		if err := contextError(ctx, err); err != nil {
+			errc <- err
 		}
 	}()
-	return ch
+	return ch, errc
 }
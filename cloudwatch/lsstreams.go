@@ -1,18 +1,25 @@
 package cloudwatch
 
 import (
-	"fmt"
-	"os"
+	"context"
 	"sort"
 
-	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 )
 
 //LsStreams lists the streams of a given stream group
 //It returns a channel where the stream names are published in order of Last Ingestion Time (the first stream is the one with older Last Ingestion Time)
-func (cwl *CW) LsStreams(groupName *string, streamName *string) <-chan *string {
+//and a channel where an eventual error is published. The error channel is closed after the stream names channel.
+func (cwl *CW) LsStreams(groupName *string, streamName *string) (<-chan *string, <-chan error) {
+	return cwl.LsStreamsWithContext(context.Background(), groupName, streamName)
+}
+
+//LsStreamsWithContext is the same as LsStreams with the addition of the ability to pass a context.
+//Once the context is done the listing stops, both channels are closed and the context error is published.
+func (cwl *CW) LsStreamsWithContext(ctx context.Context, groupName *string, streamName *string) (<-chan *string, <-chan error) {
 	ch := make(chan *string)
+	errc := make(chan error, 1)
 
 	params := &cloudwatchlogs.DescribeLogStreamsInput{
 		LogGroupName: groupName}
@@ -20,27 +27,27 @@ func (cwl *CW) LsStreams(groupName *string, streamName *string) <-chan *string {
 		params.LogStreamNamePrefix = streamName
 	}
 	handler := func(res *cloudwatchlogs.DescribeLogStreamsOutput, lastPage bool) bool {
-		sort.SliceStable(res.LogStreams, func(i, j int) bool {
-			return *res.LogStreams[i].LastIngestionTime < *res.LogStreams[j].LastIngestionTime
+		sort.SliceStable(res.LogStreams, func(i, j int) bool { //streams without events have no LastIngestionTime, they come first
+			return aws.Int64Value(res.LogStreams[i].LastIngestionTime) < aws.Int64Value(res.LogStreams[j].LastIngestionTime)
 		})
 
 		for _, logStream := range res.LogStreams {
-			ch <- logStream.LogStreamName
-		}
-		if lastPage {
-			close(ch)
+			select {
+			case ch <- logStream.LogStreamName:
+			case <-ctx.Done():
+				return false
+			}
 		}
 		return !lastPage
 	}
 
 	go func() {
-		err := cwl.awsClwClient.DescribeLogStreamsPages(params, handler)
-		if err != nil {
-			if awsErr, ok := err.(awserr.Error); ok {
-				fmt.Fprintln(os.Stderr, awsErr.Message())
-				os.Exit(1)
-			}
+		defer close(errc)
+		defer close(ch)
+		err := cwl.awsClwClient.DescribeLogStreamsPagesWithContext(ctx, params, handler)
+		if err := contextError(ctx, err); err != nil {
+			errc <- err
 		}
 	}()
-	return ch
+	return ch, errc
 }
@@ -0,0 +1,188 @@
+package cloudwatch
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+//maxFilterStreams is the maximum number of stream names FilterLogEvents accepts.
+const maxFilterStreams = 100
+
+//allStreams is the cursor key of the shard made of all the streams of a group, when no prefix is given.
+const allStreams = ""
+
+//shardStreams sorts the stream names and splits them in shards FilterLogEvents accepts.
+//A nil list, meaning all the streams of the group, is a single nil shard.
+func shardStreams(names []*string) [][]*string {
+	if names == nil {
+		return [][]*string{nil}
+	}
+	sorted := make([]*string, len(names))
+	copy(sorted, names)
+	sort.Slice(sorted, func(i, j int) bool { return *sorted[i] < *sorted[j] })
+
+	var shards [][]*string
+	for len(sorted) > maxFilterStreams {
+		shards = append(shards, sorted[:maxFilterStreams])
+		sorted = sorted[maxFilterStreams:]
+	}
+	return append(shards, sorted)
+}
+
+func shardKeys(shard []*string) []string {
+	if shard == nil {
+		return []string{allStreams}
+	}
+	keys := make([]string, len(shard))
+	for i, name := range shard {
+		keys[i] = *name
+	}
+	return keys
+}
+
+//cursors tracks, per stream, the timestamp the next poll of the stream starts from.
+//Streams seen for the first time start from the most recent cursor, like a single cursor for the whole group would.
+type cursors struct {
+	byStream map[string]int64
+	latest   int64
+}
+
+func newCursors(start int64) *cursors {
+	return &cursors{byStream: make(map[string]int64), latest: start}
+}
+
+//track registers the given streams and forgets the ones not listed anymore.
+func (c *cursors) track(shards [][]*string) {
+	current := make(map[string]bool)
+	for _, shard := range shards {
+		for _, key := range shardKeys(shard) {
+			current[key] = true
+			if _, ok := c.byStream[key]; !ok {
+				c.byStream[key] = c.latest
+			}
+		}
+	}
+	for key := range c.byStream {
+		if !current[key] {
+			delete(c.byStream, key)
+		}
+	}
+}
+
+//start returns the cursor of a shard: the oldest cursor of its streams.
+func (c *cursors) start(shard []*string) int64 {
+	start := c.latest
+	for _, key := range shardKeys(shard) {
+		if ts, ok := c.byStream[key]; ok && ts < start {
+			start = ts
+		}
+	}
+	return start
+}
+
+//oldest returns the oldest cursor of all the tracked streams.
+func (c *cursors) oldest() int64 {
+	oldest := c.latest
+	for _, ts := range c.byStream {
+		if ts < oldest {
+			oldest = ts
+		}
+	}
+	return oldest
+}
+
+//advance moves the cursors of the streams of a shard to ts, unless they are already past it.
+func (c *cursors) advance(shard []*string, ts int64) {
+	for _, key := range shardKeys(shard) {
+		if ts > c.byStream[key] {
+			c.byStream[key] = ts
+		}
+	}
+	if ts > c.latest {
+		c.latest = ts
+	}
+}
+
+type shardEvent struct {
+	event *cloudwatchlogs.FilteredLogEvent
+	shard int
+}
+
+//shardEvents is a min heap of events by timestamp, events with the same timestamp are sorted by shard.
+type shardEvents []shardEvent
+
+func (h shardEvents) Len() int { return len(h) }
+func (h shardEvents) Less(i, j int) bool {
+	if *h[i].event.Timestamp == *h[j].event.Timestamp {
+		return h[i].shard < h[j].shard
+	}
+	return *h[i].event.Timestamp < *h[j].event.Timestamp
+}
+func (h shardEvents) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *shardEvents) Push(x interface{}) { *h = append(*h, x.(shardEvent)) }
+func (h *shardEvents) Pop() interface{} {
+	old := *h
+	ev := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return ev
+}
+
+type filterFunc func(input *cloudwatchlogs.FilterLogEventsInput, fn func(*cloudwatchlogs.FilterLogEventsOutput, bool) bool) error
+
+//filterShards queries every shard once, each query waiting for a value from the limiter, and publishes the events
+//of all the shards merged by timestamp. It stops when publish returns false.
+func filterShards(ctx context.Context, o *TailOptions, shards [][]*string, start int64, filter filterFunc, publish func(*cloudwatchlogs.FilteredLogEvent) bool) error {
+	sources := make([]chan *cloudwatchlogs.FilteredLogEvent, len(shards))
+	errs := make([]error, len(shards))
+	for i, shard := range shards {
+		sources[i] = make(chan *cloudwatchlogs.FilteredLogEvent, 100)
+		go func(i int, shard []*string) {
+			defer close(sources[i])
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-o.Limiter:
+				if !ok {
+					return
+				}
+			}
+			errs[i] = filter(params(o, shard, start), func(res *cloudwatchlogs.FilterLogEventsOutput, lastPage bool) bool {
+				for _, event := range res.Events {
+					select {
+					case sources[i] <- event:
+					case <-ctx.Done():
+						return false
+					}
+				}
+				return !lastPage
+			})
+		}(i, shard)
+	}
+
+	h := &shardEvents{}
+	next := func(i int) {
+		if event, ok := <-sources[i]; ok {
+			heap.Push(h, shardEvent{event: event, shard: i})
+		}
+	}
+	for i := range sources {
+		next(i)
+	}
+	for h.Len() > 0 {
+		item := heap.Pop(h).(shardEvent)
+		if !publish(item.event) {
+			return nil
+		}
+		next(item.shard)
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
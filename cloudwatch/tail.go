@@ -1,8 +1,8 @@
 package cloudwatch
 
 import (
-	"fmt"
-	"os"
+	"context"
+	"errors"
 	"regexp"
 	"sync"
 	"time"
@@ -12,6 +12,116 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 )
 
+const (
+	defaultDedupMaxEvents        = 100000
+	defaultStreamRefreshInterval = 5 * time.Second
+)
+
+//Filter is a client side filter on the event message.
+//It returns true when the event has to be published.
+type Filter func(message string) bool
+
+//ExcludeMatching returns a Filter discarding the messages matching the given regular expression,
+//the equivalent of grep --invert-match.
+func ExcludeMatching(re *regexp.Regexp) Filter {
+	return func(message string) bool {
+		return !re.MatchString(message)
+	}
+}
+
+//IncludeMatchingAny returns a Filter accepting the messages matching at least one of the given regular expressions.
+func IncludeMatchingAny(res ...*regexp.Regexp) Filter {
+	return func(message string) bool {
+		for _, re := range res {
+			if re.MatchString(message) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+//IncludeMatchingAll returns a Filter accepting the messages matching all the given regular expressions.
+func IncludeMatchingAll(res ...*regexp.Regexp) Filter {
+	return func(message string) bool {
+		for _, re := range res {
+			if !re.MatchString(message) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+//TailOptions configures a tail.
+//Only LogGroupName and Limiter are required, all the other fields have sensible zero values.
+type TailOptions struct {
+	//LogGroupName is the log group to tail.
+	LogGroupName string
+	//LogStreamNamePrefix restricts the tail to the streams whose name starts with the prefix.
+	//All the streams of the group are tailed when empty.
+	LogStreamNamePrefix string
+	//LogStreamNameRegexp restricts the tail to the streams whose name matches the regular expression, on top of LogStreamNamePrefix.
+	//The regular expression is evaluated against the streams listing, refreshed every StreamRefreshInterval.
+	LogStreamNameRegexp *regexp.Regexp
+	//ExcludeStreams are regular expressions: the streams whose name matches any of them are not tailed, i.e. sidecar streams.
+	ExcludeStreams []*regexp.Regexp
+	//Follow keeps polling for new events once the end of the streams is reached.
+	Follow bool
+	//StartTime is the time of the oldest event to publish.
+	StartTime time.Time
+	//EndTime is the time of the most recent event to publish. It is ignored when Follow is true.
+	EndTime time.Time
+	//FilterPattern is the Cloudwatch filter pattern applied server side.
+	//See http://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/FilterAndPatternSyntax.html for syntax.
+	FilterPattern string
+	//Filters are applied client side: an event is published only if all of them accept its message.
+	Filters []Filter
+	//Limiter triggers the polling requests; every value received allows one poll.
+	//Share the rate across tails to stay within the Cloudwatch API limits.
+	//When more than 100 streams match LogStreamNamePrefix they are polled in batches of 100, one batch per value received.
+	Limiter <-chan time.Time
+	//DedupMaxEvents is the maximum number of IDs of published events remembered to discard the duplicates returned by the following polls.
+	//IDs are forgotten once the polls start after the timestamp of their event; beyond the limit the oldest ones are forgotten first,
+	//which can let duplicates through. Defaults to 100000.
+	DedupMaxEvents int
+	//StreamRefreshInterval is how often the streams matching LogStreamNamePrefix, LogStreamNameRegexp and ExcludeStreams are refreshed.
+	//Defaults to 5 seconds.
+	StreamRefreshInterval time.Duration
+	//Overlap is how far before the timestamp of the last published event each poll starts when Follow is true,
+	//to recover the events ingested late, i.e. by batching agents. Events already published are discarded.
+	//Zero, the default, polls from the timestamp of the last published event.
+	Overlap time.Duration
+	//Label is copied to the published events, to tell apart the tails sharing the same output.
+	Label string
+}
+
+func (o *TailOptions) accept(message string) bool {
+	for _, f := range o.Filters {
+		if !f(message) {
+			return false
+		}
+	}
+	return true
+}
+
+//selectsStreams returns true when only some of the streams are tailed, and they must be listed.
+func (o *TailOptions) selectsStreams() bool {
+	return o.LogStreamNamePrefix != "" || o.LogStreamNameRegexp != nil || len(o.ExcludeStreams) > 0
+}
+
+func (o *TailOptions) acceptStream(name string) bool {
+	if o.LogStreamNameRegexp != nil && !o.LogStreamNameRegexp.MatchString(name) {
+		return false
+	}
+	for _, re := range o.ExcludeStreams {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
 type logStreams struct {
 	groupStreams []*string
 	sync.RWMutex
@@ -29,139 +139,254 @@ func (s *logStreams) get() []*string {
 	return s.groupStreams
 }
 
-func params(logGroupName string, streamNames []*string, startTimeInMillis int64, endTimeInMillis int64, grep *string, follow *bool) *cloudwatchlogs.FilterLogEventsInput {
+func params(opts *TailOptions, streamNames []*string, startTimeInMillis int64) *cloudwatchlogs.FilterLogEventsInput {
 	params := &cloudwatchlogs.FilterLogEventsInput{
-		LogGroupName: &logGroupName,
+		LogGroupName: aws.String(opts.LogGroupName),
 		Interleaved:  aws.Bool(true),
 		StartTime:    &startTimeInMillis}
 
-	if *grep != "" {
-		params.FilterPattern = grep
+	if opts.FilterPattern != "" {
+		params.FilterPattern = aws.String(opts.FilterPattern)
 	}
 
 	if streamNames != nil {
 		params.LogStreamNames = streamNames
 	}
 
-	if !*follow && endTimeInMillis != 0 {
-		params.EndTime = &endTimeInMillis
+	if !opts.Follow && !opts.EndTime.IsZero() {
+		params.EndTime = aws.Int64(opts.EndTime.Unix() * 1000)
 	}
 	return params
 }
 
-//Tail tails the given stream names in the specified log group name
-//To tail all the available streams logStreamName has to be '*'
-//It returns a channel where logs line are published
-//Unless the follow flag is true the channel is closed once there are no more events available
-func (cwl *CW) Tail(logGroupName *string, logStreamName *string, follow *bool, startTime *time.Time, endTime *time.Time, grep *string, grepv *string, limiter <-chan time.Time) <-chan *cloudwatchlogs.FilteredLogEvent {
-	lastSeenTimestamp := startTime.Unix() * 1000
+//ErrNoStreams is published by Tail when no log stream matches the given stream name prefix
+var ErrNoStreams = errors.New("no such log stream(s)")
 
-	var endTimeInMillis int64
-	if !endTime.IsZero() {
-		endTimeInMillis = endTime.Unix() * 1000
+//Tail tails the log group described by the given options
+//It returns a channel where logs line are published and a channel where an eventual error is published.
+//Unless the follow option is true the channel is closed once there are no more events available.
+//On error the logs channel is closed too; the error channel is always closed after the logs channel.
+func (cwl *CW) Tail(opts *TailOptions) (<-chan *Event, <-chan error) {
+	return cwl.TailWithContext(context.Background(), opts)
+}
+
+//TailWithContext is the same as Tail with the addition of the ability to pass a context.
+//Once the context is done polling stops, in-flight requests are aborted, both channels are closed and the context error is published.
+//All the goroutines started by the tail terminate when the tail ends, either because of the context or because there are no more events.
+func (cwl *CW) TailWithContext(ctx context.Context, opts *TailOptions) (<-chan *Event, <-chan error) {
+	o := *opts
+	if o.DedupMaxEvents == 0 {
+		o.DedupMaxEvents = defaultDedupMaxEvents
+	}
+	if o.StreamRefreshInterval == 0 {
+		o.StreamRefreshInterval = defaultStreamRefreshInterval
+	}
+
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+
+	startTimeInMillis := o.StartTime.Unix() * 1000
+
+	ch := make(chan *Event, 1000)
+	errc := make(chan error, 1)
+	finish := func(err error) {
+		cancel()
+		if err := contextError(parentCtx, err); err != nil {
+			errc <- err
+		}
+		close(ch)
+		close(errc)
+	}
+
+	if o.LogGroupName == "" || o.Limiter == nil {
+		finish(errors.New("cloudwatch: LogGroupName and Limiter are required to tail"))
+		return ch, errc
 	}
 
-	ch := make(chan *cloudwatchlogs.FilteredLogEvent, 1000)
 	idle := make(chan bool, 1)
 	idle <- true
 
-	ttl := 60 * time.Second
-	cache := createCache(ttl, cwl.log)
+	cache := newEventCache(o.DedupMaxEvents)
 
 	logStreams := &logStreams{}
+	refreshErrc := make(chan error, 1)
 
-	if logStreamName != nil && *logStreamName != "" {
-		getStreams := func(logGroupName *string, logStreamName *string) []*string {
+	if o.selectsStreams() {
+		var prefix *string
+		if o.LogStreamNamePrefix != "" {
+			prefix = &o.LogStreamNamePrefix
+		}
+		getStreams := func() ([]*string, error) {
 			var streams []*string
-			for stream := range cwl.LsStreams(logGroupName, logStreamName) {
-				streams = append(streams, stream)
+			names, errc := cwl.LsStreamsWithContext(ctx, &o.LogGroupName, prefix)
+			for stream := range names {
+				if o.acceptStream(*stream) {
+					streams = append(streams, stream)
+				}
 			}
-			if len(streams) == 0 {
-				fmt.Fprintln(os.Stderr, "No such log stream(s).")
-				close(ch)
+			if err := <-errc; err != nil {
+				return nil, err
 			}
-			if len(streams) >= 100 { //FilterLogEventPages won't take more than 100 stream names
-				start := len(streams) - 100
-				streams = streams[start:]
+			if len(streams) == 0 {
+				return nil, ErrNoStreams
 			}
-			return streams
+			return streams, nil
 		}
-		logStreams.reset(getStreams(logGroupName, logStreamName))
+		streams, err := getStreams()
+		if err != nil {
+			finish(err)
+			return ch, errc
+		}
+		logStreams.reset(streams)
 
-		go func() { //refresh known streams every 5 seconds
-			ticker := time.NewTicker(time.Second * 5)
-			for range ticker.C {
-				logStreams.reset(getStreams(logGroupName, logStreamName))
+		go func() { //refresh known streams periodically
+			ticker := time.NewTicker(o.StreamRefreshInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				streams, err := getStreams()
+				if err != nil {
+					if isThrottlingError(err) {
+						cwl.log.Printf("Rate exceeded refreshing streams of %s. Retry at next refresh.\n", o.LogGroupName)
+						continue
+					}
+					refreshErrc <- err
+					return
+				}
+				logStreams.reset(streams)
 			}
 		}()
 	}
 
-	re := regexp.MustCompile(*grepv)
-	pageHandler := func(res *cloudwatchlogs.FilterLogEventsOutput, lastPage bool) bool {
-		for _, event := range res.Events {
-			if *grepv == "" || !re.MatchString(*event.Message) {
-
-				if !cache.Has(*event.EventId) {
-					eventTimestamp := *event.Timestamp
+	overlapInMillis := int64(o.Overlap / time.Millisecond)
+	cursors := newCursors(startTimeInMillis)
+	var lastSeenTimestamp int64 //timestamp of the last event published by the current poll
+	recovered := 0
 
-					if eventTimestamp != lastSeenTimestamp {
-						if eventTimestamp < lastSeenTimestamp {
-							cwl.log.Printf("old event:%s, ev-ts:%d, last-ts:%d, cache-size:%d \n", event, eventTimestamp, lastSeenTimestamp, cache.Size())
-						}
-						lastSeenTimestamp = eventTimestamp
-					}
-					cache.Add(*event.EventId, *event.Timestamp)
-					ch <- event
-				} else {
-					cwl.log.Printf("%s already seen\n", *event.EventId)
+	//publish publishes an event accepted by the filters and not published yet. It returns false once the tail is done.
+	publish := func(event *cloudwatchlogs.FilteredLogEvent) bool {
+		if !o.accept(*event.Message) {
+			return true
+		}
+		if cache.Has(*event.EventId) {
+			cwl.log.Printf("%s already seen\n", *event.EventId)
+			return true
+		}
+		eventTimestamp := *event.Timestamp
+		if eventTimestamp < lastSeenTimestamp {
+			cwl.log.Printf("old event:%s, ev-ts:%d, last-ts:%d, cache-size:%d \n", event, eventTimestamp, lastSeenTimestamp, cache.Size())
+			recovered++
+		} else {
+			lastSeenTimestamp = eventTimestamp
+		}
+		cache.Add(*event.EventId, eventTimestamp)
+		ev := cwl.newEvent(o.LogGroupName, event)
+		ev.Label = o.Label
+		ev.Filtered = o.FilterPattern != "" || len(o.Filters) > 0
+		select {
+		case ch <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 
-				}
+	pageHandler := func(res *cloudwatchlogs.FilterLogEventsOutput, lastPage bool) bool {
+		for _, event := range res.Events {
+			if !publish(event) {
+				return false
 			}
 		}
 
-		if lastPage {
-			if !*follow {
-				close(ch)
-			} else {
-				cwl.log.Println("last page")
-				idle <- true
+		if lastPage && o.Follow {
+			cwl.log.Println("last page")
+			if recovered > 0 {
+				cwl.log.Printf("%s: recovered %d late event(s) within the %s overlap\n", o.LogGroupName, recovered, o.Overlap)
+				recovered = 0
 			}
+			cwl.log.Printf("%s: dedup cache size:%d %s\n", o.LogGroupName, cache.Size(), cache.Stats())
+			idle <- true
 		}
 		return !lastPage
 	}
 
+	//withOverlap moves a cursor back by the overlap, not before the start time.
+	withOverlap := func(cursor int64) int64 {
+		start := cursor - overlapInMillis
+		if start < startTimeInMillis {
+			return startTimeInMillis
+		}
+		return start
+	}
+
+	filter := func(input *cloudwatchlogs.FilterLogEventsInput, fn func(*cloudwatchlogs.FilterLogEventsOutput, bool) bool) error {
+		err := cwl.awsClwClient.FilterLogEventsPagesWithContext(ctx, input, fn)
+		if err != nil && isThrottlingError(err) {
+			cwl.log.Printf("Rate exceeded for %s. Wait for 250ms then retry.\n", o.LogGroupName)
+
+			//Wait and fire request again. 1 Retry allowed.
+			select {
+			case <-time.After(250 * time.Millisecond):
+			case <-ctx.Done():
+			}
+			err = cwl.awsClwClient.FilterLogEventsPagesWithContext(ctx, input, fn)
+		}
+		return err
+	}
+
+	if !o.Follow {
+		//streams are sharded to stay within the FilterLogEvents limit, the shards are merged to keep the events sorted
+		go func() {
+			finish(filterShards(ctx, &o, shardStreams(logStreams.get()), startTimeInMillis, filter, publish))
+		}()
+		return ch, errc
+	}
+
 	go func() {
-		for range limiter {
+		next := 0 //the shards are polled in turn, one per value from the limiter
+		for {
 			select {
-			case <-idle:
-				logParam := params(*logGroupName, logStreams.get(), lastSeenTimestamp, endTimeInMillis, grep, follow)
-				error := cwl.awsClwClient.FilterLogEventsPages(logParam, pageHandler)
-				if error != nil {
-					if awsErr, ok := error.(awserr.Error); ok {
-						if awsErr.Code() == "ThrottlingException" {
-							cwl.log.Printf("Rate exceeded for %s. Wait for 250ms then retry.\n", *logGroupName)
-
-							//Wait and fire request again. 1 Retry allowed.
-							time.Sleep(250 * time.Millisecond)
-
-							error := cwl.awsClwClient.FilterLogEventsPages(logParam, pageHandler)
-							if error != nil {
-								if awsErr, ok := error.(awserr.Error); ok {
-									fmt.Fprintln(os.Stderr, awsErr.Message())
-									os.Exit(1)
-								}
-							}
-						} else {
-							fmt.Fprintln(os.Stderr, awsErr.Message())
-							os.Exit(1)
-						}
+			case <-ctx.Done():
+				finish(nil)
+				return
+			case err := <-refreshErrc:
+				finish(err)
+				return
+			case _, ok := <-o.Limiter:
+				if !ok {
+					finish(nil)
+					return
+				}
+				select {
+				case <-idle:
+					shards := shardStreams(logStreams.get())
+					cursors.track(shards)
+					shard := shards[next%len(shards)]
+					next++
+
+					lastSeenTimestamp = cursors.start(shard)
+					cache.Expire(withOverlap(cursors.oldest()))
+					err := filter(params(&o, shard, withOverlap(lastSeenTimestamp)), pageHandler)
+					if err != nil {
+						finish(err)
+						return
 					}
+					cursors.advance(shard, lastSeenTimestamp)
+				case <-time.After(5 * time.Millisecond):
+					cwl.log.Printf("%s still tailing, Skip polling.\n", o.LogGroupName)
 				}
-			case <-time.After(5 * time.Millisecond):
-				cwl.log.Printf("%s still tailing, Skip polling.\n", *logGroupName)
 			}
 		}
 	}()
 
-	return ch
+	return ch, errc
+}
+
+func isThrottlingError(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == "ThrottlingException"
 }
@@ -0,0 +1,401 @@
+package cloudwatch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/lucagrulla/cw/cloudwatch/fake"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCW(logs *fake.Logs) *CW {
+	return NewWithClient(logs, log.New(ioutil.Discard, "", log.LstdFlags))
+}
+
+func collect(t *testing.T, ch <-chan *Event, n int) []string {
+	var msgs []string
+	for len(msgs) < n {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return msgs
+			}
+			msgs = append(msgs, ev.Message)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout after %d of %d events", len(msgs), n)
+		}
+	}
+	return msgs
+}
+
+func TestTail(t *testing.T) {
+	a := assert.New(t)
+	logs := fake.New()
+	start := time.Now().Add(-time.Minute)
+	logs.PutLogEvent("group", "a", start.Add(1*time.Second), "first")
+	logs.PutLogEvent("group", "b", start.Add(2*time.Second), "second")
+	logs.PutLogEvent("group", "a", start.Add(3*time.Second), "third")
+
+	limiter := time.NewTicker(5 * time.Millisecond)
+	defer limiter.Stop()
+
+	ch, _ := newTestCW(logs).Tail(&TailOptions{LogGroupName: "group",
+		StartTime: start,
+		Filters:   []Filter{ExcludeMatching(regexp.MustCompile("sec"))},
+		Limiter:   limiter.C})
+
+	a.Equal([]string{"first", "third"}, collect(t, ch, 3))
+}
+
+func TestTailFollowDeduplicatesAndRetries(t *testing.T) {
+	a := assert.New(t)
+	logs := fake.New()
+	start := time.Now().Add(-time.Minute)
+	ts := start.Add(time.Second)
+	logs.PutLogEvent("group", "a", ts, "first")
+
+	limiter := make(chan time.Time, 1)
+
+	ch, _ := newTestCW(logs).Tail(&TailOptions{LogGroupName: "group",
+		LogStreamNamePrefix: "a",
+		Follow:              true,
+		StartTime:           start,
+		Limiter:             limiter})
+
+	limiter <- time.Now()
+	a.Equal([]string{"first"}, collect(t, ch, 1))
+
+	// same timestamp as the last seen event: it is queried again together with the new one
+	logs.PutLogEvent("group", "a", ts, "second")
+	logs.Throttle(1)
+	limiter <- time.Now()
+	a.Equal([]string{"second"}, collect(t, ch, 1))
+
+	limiter <- time.Now()
+	select {
+	case ev := <-ch:
+		a.Fail("unexpected duplicate event", ev.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTailFollowRecoversLateEvents(t *testing.T) {
+	a := assert.New(t)
+	start := time.Now().Add(-time.Minute)
+
+	for _, overlap := range []time.Duration{0, 10 * time.Second} {
+		logs := fake.New()
+		logs.PutLogEvent("group", "a", start.Add(10*time.Second), "first")
+
+		limiter := make(chan time.Time, 1)
+		ch, _ := newTestCW(logs).Tail(&TailOptions{LogGroupName: "group",
+			Follow:    true,
+			StartTime: start,
+			Limiter:   limiter,
+			Overlap:   overlap})
+
+		limiter <- time.Now()
+		a.Equal([]string{"first"}, collect(t, ch, 1))
+
+		// ingested now, with a timestamp older than the last published event
+		logs.PutLogEvent("group", "b", start.Add(5*time.Second), "late")
+		logs.PutLogEvent("group", "a", start.Add(11*time.Second), "second")
+		limiter <- time.Now()
+		if overlap == 0 {
+			a.Equal([]string{"second"}, collect(t, ch, 1))
+		} else {
+			a.Equal([]string{"late", "second"}, collect(t, ch, 2))
+		}
+
+		limiter <- time.Now()
+		select {
+		case ev := <-ch:
+			a.Fail("unexpected duplicate event", ev.Message)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func TestTailFollowRefreshesStreams(t *testing.T) {
+	a := assert.New(t)
+	logs := fake.New()
+	start := time.Now().Add(-time.Minute)
+	logs.PutLogEvent("group", "web-1", start.Add(time.Second), "first")
+	logs.PutLogEvent("group", "worker-1", start.Add(time.Second), "ignored")
+
+	limiter := time.NewTicker(5 * time.Millisecond)
+	defer limiter.Stop()
+
+	ch, _ := newTestCW(logs).Tail(&TailOptions{LogGroupName: "group",
+		LogStreamNamePrefix:   "web-",
+		Follow:                true,
+		StartTime:             start,
+		Limiter:               limiter.C,
+		StreamRefreshInterval: 20 * time.Millisecond})
+	a.Equal([]string{"first"}, collect(t, ch, 1))
+
+	logs.PutLogEvent("group", "web-2", start.Add(2*time.Second), "second")
+	a.Equal([]string{"second"}, collect(t, ch, 1))
+}
+
+func TestTailSelectsStreamsByRegexp(t *testing.T) {
+	a := assert.New(t)
+	logs := fake.New()
+	start := time.Now().Add(-time.Minute)
+	logs.PutLogEvent("group", "web-1", start.Add(time.Second), "first")
+	logs.PutLogEvent("group", "web-canary", start.Add(time.Second), "ignored")
+	logs.PutLogEvent("group", "web-2-healthcheck", start.Add(time.Second), "ignored")
+	logs.PutLogEvent("group", "worker-1", start.Add(time.Second), "ignored")
+	logs.CreateLogStream("group", "web-9") //no events, no LastIngestionTime
+
+	limiter := time.NewTicker(5 * time.Millisecond)
+	defer limiter.Stop()
+
+	ch, _ := newTestCW(logs).Tail(&TailOptions{LogGroupName: "group",
+		LogStreamNameRegexp:   regexp.MustCompile(`^web-[0-9]+`),
+		ExcludeStreams:        []*regexp.Regexp{regexp.MustCompile("healthcheck")},
+		Follow:                true,
+		StartTime:             start,
+		Limiter:               limiter.C,
+		StreamRefreshInterval: 20 * time.Millisecond})
+	a.Equal([]string{"first"}, collect(t, ch, 1))
+
+	logs.PutLogEvent("group", "web-3-healthcheck", start.Add(2*time.Second), "ignored")
+	logs.PutLogEvent("group", "web-3", start.Add(2*time.Second), "second")
+	a.Equal([]string{"second"}, collect(t, ch, 1))
+	select {
+	case ev := <-ch:
+		a.Fail("unexpected event", ev.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTailShardsMoreThan100Streams(t *testing.T) {
+	a := assert.New(t)
+	logs := fake.New()
+	start := time.Now().Add(-time.Minute)
+	var expected []string
+	for i := 0; i < 250; i++ {
+		// streams of different shards interleave
+		logs.PutLogEvent("group", fmt.Sprintf("web-%03d", i), start.Add(time.Duration(249-i)*time.Millisecond), fmt.Sprintf("msg-%03d", i))
+		expected = append([]string{fmt.Sprintf("msg-%03d", i)}, expected...)
+	}
+
+	limiter := time.NewTicker(5 * time.Millisecond)
+	defer limiter.Stop()
+
+	ch, errc := newTestCW(logs).Tail(&TailOptions{LogGroupName: "group",
+		LogStreamNamePrefix: "web-",
+		StartTime:           start,
+		Limiter:             limiter.C})
+
+	a.Equal(expected, collect(t, ch, 251), "events of all the shards, sorted by timestamp")
+	a.NoError(<-errc)
+	a.Equal(3, logs.Calls("FilterLogEvents"))
+
+	ch, _ = newTestCW(logs).Tail(&TailOptions{LogGroupName: "group",
+		LogStreamNamePrefix: "web-",
+		Follow:              true,
+		StartTime:           start,
+		Limiter:             limiter.C})
+	a.Len(collect(t, ch, 250), 250)
+
+	logs.PutLogEvent("group", "web-000", start.Add(time.Second), "first shard")
+	logs.PutLogEvent("group", "web-249", start.Add(time.Second), "last shard")
+	msgs := collect(t, ch, 2)
+	a.ElementsMatch([]string{"first shard", "last shard"}, msgs)
+
+	select {
+	case ev := <-ch:
+		a.Fail("unexpected duplicate event", ev.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTailPublishesErrors(t *testing.T) {
+	a := assert.New(t)
+	logs := fake.New()
+	logs.CreateLogStream("group", "web-1")
+
+	limiter := time.NewTicker(5 * time.Millisecond)
+	defer limiter.Stop()
+	opts := &TailOptions{LogGroupName: "missing",
+		Follow:    true,
+		StartTime: time.Now().Add(-time.Minute),
+		Limiter:   limiter.C}
+
+	ch, errc := newTestCW(logs).Tail(opts)
+	a.Empty(collect(t, ch, 1))
+	if awsErr, ok := (<-errc).(awserr.Error); a.True(ok) {
+		a.Equal(cloudwatchlogs.ErrCodeResourceNotFoundException, awsErr.Code())
+	}
+
+	opts.LogGroupName, opts.LogStreamNamePrefix = "group", "worker-"
+	ch, errc = newTestCW(logs).Tail(opts)
+	a.Empty(collect(t, ch, 1))
+	a.Equal(ErrNoStreams, <-errc)
+}
+
+func TestLsGroupsPublishesErrors(t *testing.T) {
+	a := assert.New(t)
+	logs := fake.New()
+	logs.SetPageSize("DescribeLogGroups", 1)
+	logs.CreateLogGroup("a")
+	logs.CreateLogGroup("b")
+	logs.Fail(nil)
+	logs.Throttle(1)
+
+	groups, errc := newTestCW(logs).LsGroups(nil)
+	var names []string
+	for g := range groups {
+		names = append(names, *g)
+	}
+	a.Equal([]string{"a"}, names)
+	a.Error(<-errc)
+	_, ok := <-errc
+	a.False(ok, "error channel must be closed")
+}
+
+func TestTailWithContextStopsOnCancel(t *testing.T) {
+	a := assert.New(t)
+	logs := fake.New()
+	start := time.Now().Add(-time.Minute)
+	logs.PutLogEvent("group", "web-1", start.Add(time.Second), "first")
+
+	limiter := time.NewTicker(5 * time.Millisecond)
+	defer limiter.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, errc := newTestCW(logs).TailWithContext(ctx, &TailOptions{LogGroupName: "group",
+		LogStreamNamePrefix: "web-",
+		Follow:              true,
+		StartTime:           start,
+		Limiter:             limiter.C})
+	a.Equal([]string{"first"}, collect(t, ch, 1))
+
+	cancel()
+	a.Empty(collect(t, ch, 1))
+	a.Equal(context.Canceled, <-errc)
+}
+
+func TestTailPublishesEvents(t *testing.T) {
+	a := assert.New(t)
+	logs := fake.New()
+	ts := time.Date(2019, 1, 1, 10, 0, 0, 123000000, time.UTC)
+	ingestion := ts.Add(2 * time.Second)
+	id := logs.PutLogEventIngestedAt("group", "web-1", ts, ingestion, "message")
+
+	limiter := time.NewTicker(5 * time.Millisecond)
+	defer limiter.Stop()
+
+	ch, _ := newTestCW(logs).Tail(&TailOptions{LogGroupName: "group",
+		StartTime: ts.Add(-time.Minute),
+		Limiter:   limiter.C})
+
+	ev := <-ch
+	if a.NotNil(ev) {
+		a.Equal(Event{Group: "group",
+			Stream:        "web-1",
+			EventID:       id,
+			Time:          ts,
+			IngestionTime: ingestion,
+			Message:       "message"}, *ev)
+	}
+
+	ch, _ = newTestCW(logs).Tail(&TailOptions{LogGroupName: "group",
+		StartTime: ts.Add(-time.Minute),
+		Filters:   []Filter{ExcludeMatching(regexp.MustCompile("debug"))},
+		Limiter:   limiter.C})
+	if ev := <-ch; a.NotNil(ev) {
+		a.True(ev.Filtered)
+	}
+}
+
+func TestEventContext(t *testing.T) {
+	a := assert.New(t)
+	logs := fake.New()
+	logs.SetPageSize("GetLogEvents", 2)
+	start := time.Now().Add(-time.Minute)
+	for i, msg := range []string{"one", "two", "three"} {
+		logs.PutLogEvent("group", "web-1", start.Add(time.Duration(i)*time.Second), msg)
+	}
+	ts := start.Add(3 * time.Second)
+	logs.PutLogEvent("group", "web-1", ts, "same ms before")
+	logs.PutLogEvent("group", "web-1", ts, "ERROR boom")
+	logs.PutLogEvent("group", "web-1", ts, "same ms after")
+	logs.PutLogEvent("group", "web-1", ts.Add(time.Second), "five")
+	logs.PutLogEvent("group", "web-2", ts.Add(time.Second), "other stream")
+
+	messages := func(events []*Event) []string {
+		var msgs []string
+		for _, ev := range events {
+			msgs = append(msgs, ev.Message)
+		}
+		return msgs
+	}
+
+	cw := newTestCW(logs)
+	match := &Event{Group: "group", Stream: "web-1", Time: millisToTime(ts.UnixNano() / int64(time.Millisecond)), Message: "ERROR boom"}
+	before, after, err := cw.EventContext(match, 3, 5, nil)
+	a.NoError(err)
+	a.Equal([]string{"two", "three", "same ms before"}, messages(before))
+	a.Equal([]string{"same ms after", "five"}, messages(after))
+	if a.Len(after, 2) {
+		a.Equal("web-1", after[1].Stream)
+		a.Empty(after[1].EventID)
+	}
+
+	logs.EmptyPages(1, 2)
+	before, after, err = cw.EventContext(match, 3, 5, nil)
+	a.NoError(err)
+	a.Equal([]string{"two", "three", "same ms before"}, messages(before), "empty pages in the middle of the stream")
+	a.Equal([]string{"same ms after", "five"}, messages(after))
+
+	match.Message = "missing"
+	before, after, err = cw.EventContext(match, 3, 3, nil)
+	a.NoError(err)
+	a.Empty(before)
+	a.Empty(after)
+
+	match.Message = "ERROR boom"
+	limiter := make(chan time.Time, 2)
+	limiter <- time.Now() //reading backwards takes two pages of two events
+	limiter <- time.Now()
+	close(limiter)
+	before, after, err = cw.EventContext(match, 1, 1, limiter)
+	a.NoError(err)
+	a.Equal([]string{"same ms before"}, messages(before))
+	a.Empty(after, "no request once the limiter is closed")
+}
+
+func TestEventCache(t *testing.T) {
+	a := assert.New(t)
+	cache := newEventCache(3)
+	cache.Add("a", 10)
+	cache.Add("b", 20)
+	cache.Add("c", 20)
+	cache.Add("c", 20)
+	a.True(cache.Has("a"))
+	a.Equal(3, cache.Size())
+
+	cache.Add("late", 5)
+	a.False(cache.Has("late"), "beyond the limit the oldest IDs are evicted first")
+	a.True(cache.Has("a"))
+
+	cache.Add("d", 30)
+	a.False(cache.Has("a"))
+
+	cache.Expire(20)
+	a.True(cache.Has("b"), "events with the same timestamp as the poll start can be returned again")
+	cache.Expire(21)
+	a.False(cache.Has("b"))
+	a.Equal(1, cache.Size())
+	a.Equal(cacheStats{added: 5, duplicates: 3, expired: 2, evicted: 2}, cache.Stats())
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+// maxPrintedEvents bounds the events remembered per stream to avoid printing them twice.
+const maxPrintedEvents = 1000
+
+// eventKey identifies an event in its stream: the events fetched as context have no ID.
+type eventKey struct {
+	time    time.Time
+	message string
+}
+
+// streamPosition remembers the events printed for a stream, and the last one,
+// to skip the events already printed, late ones included, and merge the contiguous hunks.
+type streamPosition struct {
+	printedEvents map[eventKey]bool
+	order         []eventKey
+	last          eventKey
+}
+
+func newStreamPosition() *streamPosition {
+	return &streamPosition{printedEvents: make(map[eventKey]bool)}
+}
+
+func keyOf(ev *cloudwatch.Event) eventKey {
+	return eventKey{time: ev.Time, message: ev.Message}
+}
+
+func (p *streamPosition) printed(ev *cloudwatch.Event) bool {
+	return p.printedEvents[keyOf(ev)]
+}
+
+func (p *streamPosition) add(ev *cloudwatch.Event) {
+	key := keyOf(ev)
+	p.last = key
+	if p.printedEvents[key] {
+		return
+	}
+	p.printedEvents[key] = true
+	p.order = append(p.order, key)
+	if len(p.order) > maxPrintedEvents {
+		delete(p.printedEvents, p.order[0])
+		p.order = p.order[1:]
+	}
+}
+
+// contextPrinter surrounds the events matching the filters with the events preceding and following them in their stream,
+// like grep --context. Overlapping hunks of the same stream are merged, the other ones are separated.
+type contextPrinter struct {
+	fetch     func(ev *cloudwatch.Event, before int, after int) ([]*cloudwatch.Event, []*cloudwatch.Event, error)
+	before    int
+	after     int
+	positions map[string]*streamPosition
+	last      string
+	stderr    io.Writer
+}
+
+// newContextPrinter fetches the context with the requests triggered by limiter, sharing the API rate with the tails.
+func newContextPrinter(c *cloudwatch.CW, before int, after int, limiter <-chan time.Time, stderr io.Writer) *contextPrinter {
+	return &contextPrinter{
+		fetch: func(ev *cloudwatch.Event, before int, after int) ([]*cloudwatch.Event, []*cloudwatch.Event, error) {
+			return c.EventContext(ev, before, after, limiter)
+		},
+		before:    before,
+		after:     after,
+		positions: make(map[string]*streamPosition),
+		stderr:    stderr}
+}
+
+// hunk returns the events to print for a matching event, skipping the ones already printed,
+// and whether a separator has to be printed before them because they don't continue the previous hunk.
+// Events printed as context of a previous match aren't printed again.
+func (p *contextPrinter) hunk(ev *cloudwatch.Event) ([]*cloudwatch.Event, bool) {
+	key := ev.Group + ":" + ev.Stream
+	pos, ok := p.positions[key]
+	if !ok {
+		pos = newStreamPosition()
+		p.positions[key] = pos
+	}
+	if pos.printed(ev) {
+		return nil, false
+	}
+
+	before, after, err := p.fetch(ev, p.before, p.after)
+	if err != nil {
+		fmt.Fprintf(p.stderr, "Can't fetch the context of the event at %s in %s: %s\n", ev.Time.Format(time.RFC3339), key, errorMessage(err))
+	}
+	candidates := append(append(before, ev), after...)
+
+	// the hunk continues the previous one if it starts right after the last event printed for the stream
+	var events []*cloudwatch.Event
+	continues := false
+	for i, e := range candidates {
+		if pos.printed(e) {
+			continue
+		}
+		if len(events) == 0 && i > 0 {
+			continues = keyOf(candidates[i-1]) == pos.last
+		}
+		pos.add(e)
+		events = append(events, e)
+	}
+
+	separator := p.last != "" && (p.last != key || !continues)
+	p.last = key
+	return events, separator
+}
@@ -11,6 +11,8 @@ type tailCoordinator struct {
 	targets *ring.Ring
 	sync.RWMutex
 	log *log.Logger
+	//discovery keeps the scheduler running with no targets left, as new targets can still be added.
+	discovery bool
 }
 
 func (f *tailCoordinator) start(targets []chan<- time.Time) {
@@ -24,19 +26,43 @@ func (f *tailCoordinator) start(targets []chan<- time.Time) {
 	ticker := time.NewTicker(205 * time.Millisecond)
 	go func() {
 		for range ticker.C {
-			if f.targets == nil {
+			f.Lock()
+			if f.targets.Len() == 0 {
+				f.Unlock()
+				if f.discovery {
+					continue
+				}
 				f.log.Println("coordinator: ring buffer is empty, exiting scheduler.")
+				ticker.Stop()
 				return
 			}
-			f.Lock()
 			x := f.targets.Value.(chan<- time.Time)
-			x <- time.Now()
+			select {
+			case x <- time.Now():
+			default: //the target hasn't consumed the previous trigger yet, i.e. it is blocked publishing events
+			}
 			f.targets = f.targets.Next()
 			f.Unlock()
 		}
 	}()
 }
 
+// add registers a new target, triggered last in the current round. The scheduler must have been started with discovery.
+func (f *tailCoordinator) add(c chan<- time.Time) {
+	f.Lock()
+	defer f.Unlock()
+
+	r := ring.New(1)
+	r.Value = c
+	if f.targets.Len() == 0 {
+		f.targets = r
+		f.log.Println("coordinator: first channel added")
+		return
+	}
+	f.targets.Prev().Link(r)
+	f.log.Printf("coordinator: channel added, %d channels\n", f.targets.Len())
+}
+
 func (f *tailCoordinator) remove(c chan<- time.Time) {
 	f.RLock()
 	initialLen := f.targets.Len()
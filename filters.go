@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+// compileRegexps compiles the given patterns, case insensitively if requested.
+func compileRegexps(patterns []string, ignoreCase bool) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		if p == "" {
+			continue
+		}
+		expr := p
+		if ignoreCase {
+			expr = "(?i)" + p
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse %s as a valid regular expression", p)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// includeRegexps returns the --regex and --iregex regular expressions.
+func includeRegexps() ([]*regexp.Regexp, error) {
+	res, err := compileRegexps(*regex, false)
+	if err != nil {
+		return nil, err
+	}
+	ires, err := compileRegexps(*iregex, true)
+	if err != nil {
+		return nil, err
+	}
+	return append(res, ires...), nil
+}
+
+// filterPatternFlag returns the server side filter pattern, --grep being an alias of --filter-pattern.
+func filterPatternFlag() string {
+	if *filterPattern != "" {
+		return *filterPattern
+	}
+	return *grep
+}
+
+// includeFilter returns the filter accepting the messages matching any of the given regular expressions,
+// or all of them with --regex-match all.
+func includeFilter(includes []*regexp.Regexp) cloudwatch.Filter {
+	if *regexMatch == "all" {
+		return cloudwatch.IncludeMatchingAll(includes...)
+	}
+	return cloudwatch.IncludeMatchingAny(includes...)
+}
+
+// clientFilters builds the client side filters selected by the tail flags.
+// The --regex and --iregex expressions are returned apart, to be combined with the regex options of each target by includeFilter.
+func clientFilters() ([]cloudwatch.Filter, []*regexp.Regexp, error) {
+	var filters []cloudwatch.Filter
+
+	includes, err := includeRegexps()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	excludes, err := compileRegexps([]string{*grepv}, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	iexcludes, err := compileRegexps([]string{*igrepv}, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, re := range append(excludes, iexcludes...) {
+		filters = append(filters, cloudwatch.ExcludeMatching(re))
+	}
+
+	if *minLevel != "" {
+		filters = append(filters, minLevelFilter(parseLevel(*minLevel)))
+	}
+
+	if *where != "" {
+		filter, err := parseWhere(*where)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't parse %s as a valid where expression: %s", *where, err)
+		}
+		filters = append(filters, filter)
+	}
+	return filters, includes, nil
+}
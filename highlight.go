@@ -0,0 +1,127 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// highlightColors are assigned to the highlighted terms in turn, so that each term gets its own colour.
+var highlightColors = []*color.Color{
+	color.New(color.FgBlack, color.BgYellow),
+	color.New(color.FgBlack, color.BgCyan),
+	color.New(color.FgBlack, color.BgMagenta),
+	color.New(color.FgBlack, color.BgGreen),
+	color.New(color.FgWhite, color.BgRed),
+	color.New(color.FgWhite, color.BgBlue),
+}
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+var filterPatternTerm = regexp.MustCompile(`[?-]?"[^"]*"|\S+`)
+
+// highlighter colours the matches of a list of terms inside the messages.
+// A nil highlighter leaves the messages untouched.
+type highlighter struct {
+	terms []*regexp.Regexp
+}
+
+var messageHighlighter *highlighter
+
+// highlight colours the terms found in s.
+// s can already be coloured, i.e. by --json-messages pretty: escape sequences are skipped and the
+// colour active before a highlighted term is restored after it.
+func (h *highlighter) highlight(s string) string {
+	if h == nil || len(h.terms) == 0 || color.NoColor {
+		return s
+	}
+	var b strings.Builder
+	active, last := "", 0
+	for _, loc := range ansiEscape.FindAllStringIndex(s, -1) {
+		b.WriteString(h.highlightText(s[last:loc[0]], active))
+		active = s[loc[0]:loc[1]]
+		if active == "\x1b[0m" {
+			active = ""
+		}
+		b.WriteString(s[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(h.highlightText(s[last:], active))
+	return b.String()
+}
+
+func (h *highlighter) highlightText(text string, active string) string {
+	type match struct{ start, end, term int }
+	var matches []match
+	for i, re := range h.terms {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			if loc[0] < loc[1] {
+				matches = append(matches, match{loc[0], loc[1], i})
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return text
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].start == matches[j].start {
+			return matches[i].term < matches[j].term
+		}
+		return matches[i].start < matches[j].start
+	})
+
+	var b strings.Builder
+	pos := 0
+	for _, m := range matches {
+		if m.start < pos { //overlaps an already highlighted match
+			continue
+		}
+		b.WriteString(text[pos:m.start])
+		b.WriteString(highlightColors[m.term%len(highlightColors)].Sprint(text[m.start:m.end]))
+		b.WriteString(active)
+		pos = m.end
+	}
+	b.WriteString(text[pos:])
+	return b.String()
+}
+
+// filterPatternTerms returns the terms a Cloudwatch filter pattern matches on, i.e. ERROR and "connection refused"
+// for `ERROR "connection refused" -healthcheck`. Excluded terms are skipped, JSON and space delimited patterns have no terms.
+func filterPatternTerms(pattern string) []string {
+	pattern = strings.TrimSpace(pattern)
+	if strings.HasPrefix(pattern, "{") || strings.HasPrefix(pattern, "[") {
+		return nil
+	}
+	var terms []string
+	for _, tok := range filterPatternTerm.FindAllString(pattern, -1) {
+		if strings.HasPrefix(tok, "-") {
+			continue
+		}
+		if tok = strings.Trim(strings.TrimPrefix(tok, "?"), `"`); tok != "" {
+			terms = append(terms, tok)
+		}
+	}
+	return terms
+}
+
+// highlightTerms returns the terms to highlight: the --highlight ones, case insensitively,
+// followed by the --regex, --iregex and --filter-pattern matches.
+func highlightTerms() ([]*regexp.Regexp, error) {
+	var terms []*regexp.Regexp
+	for _, word := range strings.Split(*highlight, ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			terms = append(terms, regexp.MustCompile("(?i)"+regexp.QuoteMeta(word)))
+		}
+	}
+	includes, err := includeRegexps()
+	if err != nil {
+		return nil, err
+	}
+	terms = append(terms, includes...)
+	for _, term := range filterPatternTerms(filterPatternFlag()) {
+		terms = append(terms, regexp.MustCompile(regexp.QuoteMeta(term)))
+	}
+	return terms, nil
+}
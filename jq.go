@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/itchyny/gojq"
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+// parseJQ compiles a --jq expression.
+func parseJQ(expr string) (*gojq.Code, error) {
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return gojq.Compile(query)
+}
+
+// applyJQ runs the jq program against the JSON document of the event message.
+// It returns one event per value yielded by the program, with the value as message: strings raw, anything else as compact JSON.
+// Events whose program yields nothing or fails are dropped, messages not containing JSON are returned as they are.
+func applyJQ(code *gojq.Code, ev *cloudwatch.Event) []*cloudwatch.Event {
+	prefix, doc, ok := splitJSON(ev.Message)
+	if !ok {
+		return []*cloudwatch.Event{ev}
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		return []*cloudwatch.Event{ev}
+	}
+
+	var events []*cloudwatch.Event
+	iter := code.Run(v)
+	for {
+		result, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if _, isErr := result.(error); isErr {
+			return nil
+		}
+		msg, isString := result.(string)
+		if !isString {
+			b, err := gojq.Marshal(result)
+			if err != nil {
+				return nil
+			}
+			msg = string(b)
+		}
+		transformed := *ev
+		transformed.Message = prefix + msg
+		events = append(events, &transformed)
+	}
+	return events
+}
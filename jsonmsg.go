@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+var errNotAnObject = errors.New("not a JSON object")
+
+var (
+	jsonKeyColor     = color.New(color.FgCyan)
+	jsonStringColor  = color.New(color.FgGreen)
+	jsonNumberColor  = color.New(color.FgYellow)
+	jsonLiteralColor = color.New(color.FgMagenta)
+)
+
+// splitJSON splits a message into a plain text prefix and a trailing JSON object or array.
+// Messages like Lambda's "<timestamp>\t<request id>\t{...}" keep their prefix.
+// It returns false when the message doesn't end with valid JSON.
+func splitJSON(message string) (string, string, bool) {
+	trimmed := strings.TrimSpace(message)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		if json.Valid([]byte(trimmed)) {
+			return "", trimmed, true
+		}
+	}
+	if idx := strings.Index(trimmed, "{"); idx > 0 {
+		if candidate := trimmed[idx:]; json.Valid([]byte(candidate)) {
+			return trimmed[:idx], candidate, true
+		}
+	}
+	return "", "", false
+}
+
+// renderMessage renders a message according to the --json-messages flag,
+// falling back to the original message when it doesn't contain JSON.
+func renderMessage(message string) string {
+	switch *jsonMessages {
+	case "pretty":
+		if prefix, doc, ok := splitJSON(message); ok {
+			if pretty, err := prettyJSON(doc); err == nil {
+				return prefix + pretty
+			}
+		}
+	case "kv":
+		if prefix, doc, ok := splitJSON(message); ok {
+			var order []string
+			if *jsonFields != "" {
+				order = strings.Split(*jsonFields, ",")
+			}
+			if kv, err := keyValueJSON(doc, order); err == nil {
+				return prefix + kv
+			}
+		}
+	}
+	return message
+}
+
+// prettyJSON indents a JSON document and colours it by token type, preserving the order of the keys.
+func prettyJSON(doc string) (string, error) {
+	dec := json.NewDecoder(strings.NewReader(doc))
+	dec.UseNumber()
+	var buf bytes.Buffer
+	if err := writePrettyValue(dec, &buf, 0); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func writePrettyValue(dec *json.Decoder, buf *bytes.Buffer, depth int) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		closing := "}"
+		if t == '[' {
+			closing = "]"
+		}
+		buf.WriteString(t.String())
+		empty := true
+		for dec.More() {
+			if !empty {
+				buf.WriteString(",")
+			}
+			empty = false
+			buf.WriteString("\n" + strings.Repeat("  ", depth+1))
+			if t == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				buf.WriteString(jsonKeyColor.Sprint(quoteJSON(key.(string))) + ": ")
+			}
+			if err := writePrettyValue(dec, buf, depth+1); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		if !empty {
+			buf.WriteString("\n" + strings.Repeat("  ", depth))
+		}
+		buf.WriteString(closing)
+	default:
+		buf.WriteString(colorJSONLiteral(tok))
+	}
+	return nil
+}
+
+func colorJSONLiteral(tok json.Token) string {
+	switch t := tok.(type) {
+	case string:
+		return jsonStringColor.Sprint(quoteJSON(t))
+	case json.Number:
+		return jsonNumberColor.Sprint(t.String())
+	case bool:
+		if t {
+			return jsonLiteralColor.Sprint("true")
+		}
+		return jsonLiteralColor.Sprint("false")
+	default:
+		return jsonLiteralColor.Sprint("null")
+	}
+}
+
+// keyValueJSON renders the fields of a JSON object as key=value pairs.
+// The given fields come first, in the given order, followed by the others in their original order.
+// Nested values are rendered as compact JSON.
+func keyValueJSON(doc string, order []string) (string, error) {
+	dec := json.NewDecoder(strings.NewReader(doc))
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return "", errNotAnObject
+	}
+
+	var keys []string
+	values := make(map[string]json.RawMessage)
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return "", err
+		}
+		k := key.(string)
+		if _, dup := values[k]; !dup {
+			keys = append(keys, k)
+		}
+		values[k] = raw
+	}
+
+	var pairs []string
+	printed := make(map[string]bool)
+	printPair := func(k string) {
+		raw, ok := values[k]
+		if !ok || printed[k] {
+			return
+		}
+		printed[k] = true
+		pairs = append(pairs, jsonKeyColor.Sprint(k)+"="+keyValue(raw))
+	}
+	for _, k := range order {
+		printPair(k)
+	}
+	for _, k := range keys {
+		printPair(k)
+	}
+	return strings.Join(pairs, " "), nil
+}
+
+// keyValue renders a raw JSON value for key=value output: strings unquoted unless they contain spaces, quotes or =.
+func keyValue(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			return quoteJSON(s)
+		}
+		return s
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+// quoteJSON quotes a string the JSON way, without escaping HTML characters.
+func quoteJSON(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
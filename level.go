@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+// Log levels, from the least to the most severe.
+const (
+	levelUnknown = iota - 1
+	levelTrace
+	levelDebug
+	levelInfo
+	levelWarn
+	levelError
+	levelFatal
+)
+
+var levelNames = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+var levelAliases = map[string]int{
+	"trace":       levelTrace,
+	"debug":       levelDebug,
+	"dbg":         levelDebug,
+	"info":        levelInfo,
+	"information": levelInfo,
+	"notice":      levelInfo,
+	"warn":        levelWarn,
+	"warning":     levelWarn,
+	"error":       levelError,
+	"err":         levelError,
+	"severe":      levelError,
+	"fatal":       levelFatal,
+	"critical":    levelFatal,
+	"crit":        levelFatal,
+	"panic":       levelFatal,
+	"alert":       levelFatal,
+	"emergency":   levelFatal,
+}
+
+// levelColors are the colours of the messages by level; info and unknown levels keep the default colour.
+var levelColors = map[int]color.Attribute{
+	levelTrace: color.Faint,
+	levelDebug: color.Faint,
+	levelWarn:  color.FgYellow,
+	levelError: color.FgRed,
+	levelFatal: color.FgHiRed,
+}
+
+// levelKeys are the JSON fields holding the level, compared case insensitively.
+var levelKeys = []string{"level", "severity", "lvl", "loglevel", "log_level", "log.level", "levelname"}
+
+var (
+	logfmtLevel = regexp.MustCompile(`(?i)(?:^|\s)(?:level|lvl|severity)="?([a-z]+)`)
+	// textLevel matches an upper case level within the first words of the message, i.e. Lambda's "[ERROR]\t..." or "2019-01-01 10:00:00 WARN ...".
+	textLevel = regexp.MustCompile(`^(?:\S+\s+){0,3}?\[?(TRACE|DEBUG|INFO|NOTICE|WARN|WARNING|ERROR|ERR|SEVERE|FATAL|CRITICAL|CRIT|PANIC)\]?(?:[\s:\]]|$)`)
+)
+
+// parseLevel returns the level with the given name or alias, levelUnknown if there is none.
+func parseLevel(name string) int {
+	if level, ok := levelAliases[strings.ToLower(name)]; ok {
+		return level
+	}
+	return levelUnknown
+}
+
+// detectLevel detects the level of a message from the level field of a JSON message, a logfmt level=... pair
+// or a level word at the start of a text message. It returns levelUnknown when none is found.
+func detectLevel(message string) int {
+	if _, doc, ok := splitJSON(message); ok {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(doc), &fields); err == nil {
+			return jsonLevel(fields)
+		}
+	}
+	if res := logfmtLevel.FindStringSubmatch(message); res != nil {
+		if level := parseLevel(res[1]); level != levelUnknown {
+			return level
+		}
+	}
+	if res := textLevel.FindStringSubmatch(message); res != nil {
+		return parseLevel(res[1])
+	}
+	return levelUnknown
+}
+
+// jsonLevel returns the level of the first of the levelKeys found in the fields.
+// Fields differing only by case are looked at in sorted order, so the level detected never depends on the map order.
+func jsonLevel(fields map[string]interface{}) int {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, levelKey := range levelKeys {
+		for _, key := range keys {
+			if !strings.EqualFold(key, levelKey) {
+				continue
+			}
+			if level := fieldLevel(fields[key]); level != levelUnknown {
+				return level
+			}
+		}
+	}
+	return levelUnknown
+}
+
+func fieldLevel(v interface{}) int {
+	switch value := v.(type) {
+	case string:
+		return parseLevel(value)
+	case float64: //bunyan and pino numeric levels: 10 trace ... 60 fatal
+		if value >= 10 {
+			level := int(value)/10 - 1
+			if level > levelFatal {
+				level = levelFatal
+			}
+			return level
+		}
+	}
+	return levelUnknown
+}
+
+// levelName returns the name of a level, an empty string for levelUnknown.
+func levelName(level int) string {
+	if level < levelTrace || level > levelFatal {
+		return ""
+	}
+	return levelNames[level]
+}
+
+// colorByLevel colours a whole message according to its level.
+// The level colour is restored after any colour reset inside the message, i.e. after highlighted terms.
+func colorByLevel(msg string, level int) string {
+	attr, ok := levelColors[level]
+	if !ok || color.NoColor {
+		return msg
+	}
+	start := fmt.Sprintf("\x1b[%dm", attr)
+	const reset = "\x1b[0m"
+	return start + strings.Replace(msg, reset, reset+start, -1) + reset
+}
+
+// minLevelFilter returns a filter accepting the messages of the given level or above.
+// Messages without a detectable level are treated as info.
+func minLevelFilter(min int) cloudwatch.Filter {
+	return func(message string) bool {
+		level := detectLevel(message)
+		if level == levelUnknown {
+			level = levelInfo
+		}
+		return level >= min
+	}
+}
@@ -9,12 +9,12 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 	"unicode"
 
-	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/fatih/color"
+	"github.com/itchyny/gojq"
 	"github.com/lucagrulla/cw/cloudwatch"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
@@ -27,10 +27,15 @@ const (
 var (
 	kp = kingpin.New("cw", "The best way to tail AWS Cloudwatch Logs from your terminal.")
 
-	awsProfile = kp.Flag("profile", "The target AWS profile. By default cw will use the default profile defined in the .aws/credentials file.").Short('p').String()
-	awsRegion  = kp.Flag("region", "The target AWS region. By default cw will use the default region defined in the .aws/credentials file.").Short('r').String()
-	noColor    = kp.Flag("no-color", "Disable coloured output.").Short('c').Default("false").Bool()
-	debug      = kp.Flag("debug", "Enable debug logging.").Short('d').Default("false").Hidden().Bool()
+	awsProfile     = kp.Flag("profile", "The target AWS profile. By default cw will use the default profile defined in the .aws/credentials file.").Short('p').String()
+	awsRegion      = kp.Flag("region", "The target AWS region. By default cw will use the default region defined in the .aws/credentials file.").Short('r').String()
+	awsEndpointURL = kp.Flag("endpoint-url", "The target AWS endpoint url. By default cw will use the default aws endpoints. "+
+		"Use it to target LocalStack or any other Cloudwatch logs compatible service. Can be set with the CW_ENDPOINT_URL environment variable.").Envar("CW_ENDPOINT_URL").Short('u').String()
+	noColor      = kp.Flag("no-color", "Disable coloured output.").Short('c').Default("false").Bool()
+	debug        = kp.Flag("debug", "Enable debug logging.").Short('d').Default("false").Hidden().Bool()
+	colorPalette = kp.Flag("palette", "Colours of the group and stream names, each name always getting the same colour: "+
+		"basic (16 colours terminals), 256, truecolor or a comma separated list of colour names, 256 colours indexes and #rrggbb colours, i.e. cyan,magenta,208,#5fafff.").
+		Default("basic").String()
 
 	lsCommand = kp.Command("ls", "Show an entity.")
 
@@ -42,7 +47,12 @@ var (
 	tailCommand        = kp.Command("tail", "Tail log groups/streams.")
 	logGroupStreamName = tailCommand.Arg("groupName[:logStreamPrefix]", "The log group and stream name, with group:prefix syntax."+
 		"Stream name can be just the prefix. If no stream name is specified all stream names in the given group will be tailed."+
-		"Multiple group/stream tuple can be passed. e.g. cw tail group1:prefix1 group2:prefix2 group3:prefix3.").Strings()
+		"Multiple group/stream tuple can be passed. e.g. cw tail group1:prefix1 group2:prefix2 group3:prefix3."+
+		" The group name can be a glob selecting all the matching groups, * matching any sequence of characters and ? any character. e.g. cw tail '/ecs/prod/*'."+
+		" A stream name starting with ~ is a regular expression the stream names must match. e.g. cw tail 'group:~^web-[0-9]+$'."+
+		" Options of a single target follow it as ;key=value pairs: filter, regex, grepv, exclude-stream and label. e.g. cw tail 'api;filter=ERROR;label=api' worker.").Strings()
+	groupRegex = tailCommand.Flag("group-regex", "Tail all the log groups whose name matches the regular expression, i.e. '^/aws/lambda/orders-'. "+
+		"Can be repeated. Expressions starting with ^ are narrowed down server side.").Strings()
 
 	follow          = tailCommand.Flag("follow", "Don't stop when the end of streams is reached, but rather wait for additional data to be appended.").Short('f').Default("false").Bool()
 	printTimestamp  = tailCommand.Flag("timestamp", "Print the event timestamp.").Short('t').Default("false").Bool()
@@ -60,19 +70,62 @@ var (
 		"Denote hours with 'h' and minutes with 'm' i.e. 80m, 4h30m."+
 		"If just time is used (format: hh[:mm]) it is expanded to today at the given time. Full available date/time format: 2017-02-27[T09[:00[:00]].").
 		Short('e').Default("").String()
-	local = tailCommand.Flag("local", "Treat date and time in Local timezone.").Short('l').Default("false").Bool()
-	grep  = tailCommand.Flag("grep", "Pattern to filter logs by. See http://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/FilterAndPatternSyntax.html for syntax.").
+	local         = tailCommand.Flag("local", "Treat date and time in Local timezone.").Short('l').Default("false").Bool()
+	filterPattern = tailCommand.Flag("filter-pattern", "Cloudwatch filter pattern, applied server side. "+
+		"See http://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/FilterAndPatternSyntax.html for syntax.").
 		Short('g').Default("").String()
-	grepv = tailCommand.Flag("grepv", "Equivalent of grep --invert-match. Invert match pattern to filter logs by.").Short('v').Default("").String()
+	grep       = tailCommand.Flag("grep", "Alias of --filter-pattern.").Hidden().Default("").String()
+	regex      = tailCommand.Flag("regex", "Regular expression the messages have to match, applied client side. Can be repeated, see --regex-match.").Short('x').Strings()
+	iregex     = tailCommand.Flag("iregex", "Case insensitive --regex. Can be repeated, see --regex-match.").Strings()
+	regexMatch = tailCommand.Flag("regex-match", "How multiple --regex/--iregex combine: "+
+		"any (a message has to match at least one) or all (a message has to match every one).").Default("any").Enum("any", "all")
+	grepv         = tailCommand.Flag("grepv", "Equivalent of grep --invert-match. Regular expression the messages must not match, applied client side.").Short('v').Default("").String()
+	igrepv        = tailCommand.Flag("igrepv", "Case insensitive --grepv.").Default("").String()
+	excludeStream = tailCommand.Flag("exclude-stream", "Regular expression the names of the streams to tail must not match, i.e. healthcheck to drop the sidecar streams. "+
+		"Can be repeated.").Strings()
+	output = tailCommand.Flag("output", "Output format: text or json. json prints one JSON object per event (JSON Lines) with all the event metadata.").
+		Short('o').Default("text").Enum("text", "json")
+	format = tailCommand.Flag("format", "Go template used to format each event, i.e. '{{.Time | tsfmt \"15:04:05\"}} [{{.Stream}}] {{.Message}}'. "+
+		"Available fields: .Group, .Stream, .EventID, .Time, .IngestionTime, .Message, .Profile, .Region, .Label. "+
+		"Available functions: tsfmt, color, hashcolor, pad, trunc, highlight, upper, lower, field, level.").Short('F').Default("").String()
+	jsonMessages = tailCommand.Flag("json-messages", "How to print JSON messages: raw, pretty (indented and coloured) or kv (key=value pairs). "+
+		"Messages not containing JSON are printed as they are.").Short('j').Default("raw").Enum("raw", "pretty", "kv")
+	jsonFields = tailCommand.Flag("json-fields", "Comma separated list of fields printed first, in the given order, with --json-messages=kv. i.e. level,msg").Default("").String()
+	jq         = tailCommand.Flag("jq", "jq expression applied to JSON messages before printing, i.e. '.request | {path, status}'. "+
+		"Events for which the expression yields nothing are dropped, messages not containing JSON are printed as they are.").Default("").String()
+	where = tailCommand.Flag("where", "Client side filter on JSON messages, i.e. 'level == \"error\" && latency_ms > 500'. "+
+		"Supports nested paths (request.path), ==, !=, <, <=, >, >=, in, =~ and !~ (regular expression match), &&, || and !. "+
+		"Messages not containing JSON are discarded.").Short('w').Default("").String()
+	highlight = tailCommand.Flag("highlight", "Comma separated list of terms highlighted, case insensitively, in the messages, each with its own colour, i.e. error,timeout. "+
+		"Matches of --filter-pattern, --regex and --iregex are highlighted too.").Default("").String()
+	minLevel = tailCommand.Flag("min-level", "Client side filter on the level of the messages, detected from the level/severity field of JSON messages, "+
+		"level=... pairs or a level at the start of text messages (i.e. ERROR, [WARN]): trace, debug, info, warn, error or fatal. "+
+		"Messages without a detectable level are treated as info.").Enum(levelNames...)
+	overlap = tailCommand.Flag("overlap", "With --follow, how far before the last event received each poll looks again, to catch the events ingested late, "+
+		"i.e. by batching agents or Lambda. i.e. 30s. Recovered events are printed when found, out of order unless --ordered-delay is long enough.").Default("0s").Duration()
+	dedupMaxEvents = tailCommand.Flag("dedup-max-events", "With --follow, the maximum number of event IDs remembered per log group to discard the events fetched again by the following polls. "+
+		"Raise it for high volume groups tailed with a long --overlap.").Default("100000").Int()
+	ordered = tailCommand.Flag("ordered", "Print the events of all the groups sorted by timestamp. "+
+		"Without --follow the output is strictly sorted, with --follow events are held for --ordered-delay to sort them.").Default("false").Bool()
+	orderedDelay = tailCommand.Flag("ordered-delay", "How long events are held to sort them with --ordered and --follow, i.e. 2s, 500ms. "+
+		"Longer delays sort better events from groups with different ingestion lags.").Default("2s").Duration()
+	discoveryInterval = tailCommand.Flag("discovery-interval", "With --follow, how often the log groups matching the glob arguments and --group-regex are listed again "+
+		"to start tailing the groups created meanwhile, i.e. 1m. 0 disables the discovery.").Default("30s").Duration()
+	afterContext  = tailCommand.Flag("after-context", "Print the given number of events following each matching event in its stream, like grep -A. Requires a filter.").Short('A').Default("0").Int()
+	beforeContext = tailCommand.Flag("before-context", "Print the given number of events preceding each matching event in its stream, like grep -B. Requires a filter.").Short('B').Default("0").Int()
+	eventContext  = tailCommand.Flag("context", "Print the given number of events preceding and following each matching event in its stream, like grep -C. Requires a filter.").Short('C').Default("0").Int()
 )
 
-func timestampToTime(timeStamp *string) (time.Time, error) {
-	var zone *time.Location
+// zone returns the timezone dates and times are treated in, as selected by the --local flag.
+func zone() *time.Location {
 	if *local {
-		zone = time.Local
-	} else {
-		zone = time.UTC
+		return time.Local
 	}
+	return time.UTC
+}
+
+func timestampToTime(timeStamp *string) (time.Time, error) {
+	zone := zone()
 	if regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`).MatchString(*timeStamp) {
 		t, _ := time.ParseInLocation("2006-01-02", *timeStamp, zone)
 		return t, nil
@@ -109,27 +162,23 @@ func timestampToTime(timeStamp *string) (time.Time, error) {
 	return t, nil
 }
 
-type logEvent struct {
-	logEvent cloudwatchlogs.FilteredLogEvent
-	logGroup string
-}
-
-func formatLogMsg(ev logEvent, printTime *bool, printStreamName *bool, printGroupName *bool) string {
-	msg := *ev.logEvent.Message
+func formatLogMsg(ev *cloudwatch.Event, printTime *bool, printStreamName *bool, printGroupName *bool) string {
+	msg := colorByLevel(messageHighlighter.highlight(renderMessage(ev.Message)), detectLevel(ev.Message))
 	if *printEventID {
-		msg = fmt.Sprintf("%s - %s", color.YellowString(*ev.logEvent.EventId), msg)
+		msg = fmt.Sprintf("%s - %s", color.YellowString(ev.EventID), msg)
 	}
 	if *printStreamName {
-		msg = fmt.Sprintf("%s - %s", color.BlueString(*ev.logEvent.LogStreamName), msg)
+		msg = fmt.Sprintf("%s - %s", namesPalette.sprint(ev.Stream), msg)
 	}
 
-	if *printGroupName {
-		msg = fmt.Sprintf("%s - %s", color.CyanString(ev.logGroup), msg)
+	if ev.Label != "" {
+		msg = fmt.Sprintf("%s - %s", namesPalette.sprint(ev.Label), msg)
+	} else if *printGroupName {
+		msg = fmt.Sprintf("%s - %s", namesPalette.sprint(ev.Group), msg)
 	}
 
 	if *printTime {
-		eventTimestamp := *ev.logEvent.Timestamp / 1000
-		ts := time.Unix(eventTimestamp, 0).Format(timeFormat)
+		ts := ev.Time.Local().Format(timeFormat)
 		msg = fmt.Sprintf("%s - %s", color.GreenString(ts), msg)
 	}
 	return msg
@@ -153,6 +202,20 @@ func fromStdin() []string {
 	return groups
 }
 
+// errorMessage returns the message to print for an error: AWS errors without their error code.
+func errorMessage(err error) string {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Message()
+	}
+	return err.Error()
+}
+
+// exitWithError prints the given error to stderr and exits.
+func exitWithError(err error) {
+	fmt.Fprintln(os.Stderr, errorMessage(err))
+	os.Exit(1)
+}
+
 func main() {
 	log := log.New(ioutil.Discard, "", log.LstdFlags)
 	kp.Version(version).Author("Luca Grulla")
@@ -169,26 +232,49 @@ func main() {
 		color.NoColor = true
 	}
 
-	c := cloudwatch.New(awsProfile, awsRegion, log)
+	pal, err := parsePalette(*colorPalette)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't parse %s as a valid palette: %s\n", *colorPalette, err)
+		os.Exit(1)
+	}
+	namesPalette = pal
+
+	c := cloudwatch.New(awsProfile, awsRegion, awsEndpointURL, log)
 
 	switch cmd {
 	case "ls groups":
-
-		for msg := range c.LsGroups() {
+		groups, errc := c.LsGroups(nil)
+		for msg := range groups {
 			fmt.Println(*msg)
 		}
+		if err := <-errc; err != nil {
+			exitWithError(err)
+		}
 	case "ls streams":
-		for msg := range c.LsStreams(lsLogGroupName, nil) {
+		streams, errc := c.LsStreams(lsLogGroupName, nil)
+		for msg := range streams {
 			fmt.Println(*msg)
 		}
+		if err := <-errc; err != nil {
+			exitWithError(err)
+		}
 	case "tail":
 		if additionalInput := fromStdin(); additionalInput != nil {
 			*logGroupStreamName = append(*logGroupStreamName, additionalInput...)
 		}
-		if len(*logGroupStreamName) == 0 {
+		if len(*logGroupStreamName) == 0 && len(*groupRegex) == 0 {
 			fmt.Fprintln(os.Stderr, "cw: error: required argument 'groupName[:logStreamPrefix]' not provided, try --help")
 			os.Exit(1)
 		}
+		targets, selectors, err := resolveTargets(*logGroupStreamName, *groupRegex, c.LsGroups)
+		if err != nil {
+			exitWithError(err)
+		}
+		discovery := *follow && *discoveryInterval > 0 && len(selectors) > 0
+		if len(targets) == 0 && !discovery {
+			fmt.Fprintln(os.Stderr, "cw: error: no log group to tail")
+			os.Exit(1)
+		}
 
 		st, err := timestampToTime(startTime)
 		if err != nil {
@@ -205,43 +291,162 @@ func main() {
 				et = endT
 			}
 		}
-		out := make(chan *logEvent)
+		if *format != "" {
+			if *output == "json" {
+				fmt.Fprintln(os.Stderr, "cw: error: --format can't be used with --output json")
+				os.Exit(1)
+			}
+			tmpl, err := parseFormat(*format)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "can't parse %s as a valid format: %s\n", *format, err)
+				os.Exit(1)
+			}
+			formatTemplate = tmpl
+		}
+
+		var jqCode *gojq.Code
+		if *jq != "" {
+			code, err := parseJQ(*jq)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "can't parse %s as a valid jq expression: %s\n", *jq, err)
+				os.Exit(1)
+			}
+			jqCode = code
+		}
 
-		var wg sync.WaitGroup
+		filters, includes, err := clientFilters()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		excludedStreams, err := compileRegexps(*excludeStream, false)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		terms, err := highlightTerms()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		messageHighlighter = &highlighter{terms: terms}
 
-		triggerChannels := make([]chan<- time.Time, len(*logGroupStreamName))
+		if *eventContext > 0 {
+			if *beforeContext == 0 {
+				*beforeContext = *eventContext
+			}
+			if *afterContext == 0 {
+				*afterContext = *eventContext
+			}
+		}
+		var contexts *contextPrinter
+		var contextTrigger chan time.Time
+		if *beforeContext > 0 || *afterContext > 0 {
+			if filterPatternFlag() == "" && len(filters) == 0 && len(includes) == 0 && !targetsFiltered(targets) {
+				fmt.Fprintln(os.Stderr, "cw: error: --context, --before-context and --after-context require --filter-pattern, --regex, --grepv, --where, --min-level or a target filter option")
+				os.Exit(1)
+			}
+			contextTrigger = make(chan time.Time, 1)
+			contexts = newContextPrinter(c, *beforeContext, *afterContext, contextTrigger, os.Stderr)
+		}
 
-		coordinator := &tailCoordinator{log: log}
-		for idx, gs := range *logGroupStreamName {
-			trigger := make(chan time.Time, 1)
-			go func(groupStream string) {
-				tokens := strings.Split(groupStream, ":")
-				var prefix string
-				group := tokens[0]
-				if len(tokens) > 1 && tokens[1] != "*" {
-					prefix = tokens[1]
+		coordinator := &tailCoordinator{log: log, discovery: discovery}
+		// startTail tails a target; a discovered target failing is skipped instead of ending the whole tail.
+		startTail := func(t target, trigger chan time.Time, discovered bool) <-chan *cloudwatch.Event {
+			groupEvents := make(chan *cloudwatch.Event)
+			go func() {
+				defer close(groupEvents)
+				opts := &cloudwatch.TailOptions{LogGroupName: t.group,
+					LogStreamNamePrefix: t.prefix,
+					LogStreamNameRegexp: t.streamRegexp,
+					ExcludeStreams:      excludedStreams,
+					Follow:              *follow,
+					StartTime:           st,
+					EndTime:             et,
+					FilterPattern:       filterPatternFlag(),
+					Filters:             filters,
+					Limiter:             trigger,
+					Overlap:             *overlap,
+					DedupMaxEvents:      *dedupMaxEvents}
+				t.options.apply(opts, includes)
+				events, errc := c.Tail(opts)
+				for ev := range events {
+					groupEvents <- ev
 				}
-				for c := range c.Tail(&group, &prefix, follow, &st, &et, grep, grepv, trigger) {
-					out <- &logEvent{logEvent: *c, logGroup: group}
+				if err := <-errc; err != nil {
+					switch {
+					case err == cloudwatch.ErrNoStreams:
+						fmt.Fprintln(os.Stderr, "No such log stream(s).")
+					case discovered:
+						fmt.Fprintf(os.Stderr, "Skipping the new log group %s: %s\n", t.group, errorMessage(err))
+					default:
+						exitWithError(err)
+					}
 				}
 				coordinator.remove(trigger)
-				wg.Done()
-			}(gs)
+			}()
+			return groupEvents
+		}
+
+		triggerChannels := make([]chan<- time.Time, len(targets))
+		sources := make([]<-chan *cloudwatch.Event, len(targets))
+		for idx, t := range targets {
+			trigger := make(chan time.Time, 1)
 			triggerChannels[idx] = trigger
-			wg.Add(1)
+			sources[idx] = startTail(t, trigger, false)
 		}
 
+		if contextTrigger != nil { //context requests take turns with the polls of the tails
+			triggerChannels = append(triggerChannels, contextTrigger)
+		}
 		coordinator.start(triggerChannels)
 
-		go func() {
-			wg.Wait()
-			log.Println("closing main channel...")
+		var more chan (<-chan *cloudwatch.Event)
+		if discovery {
+			more = make(chan (<-chan *cloudwatch.Event))
+			go discoverTargets(selectors, targets, *discoveryInterval, c.LsGroups, func(t target) {
+				trigger := make(chan time.Time, 1)
+				coordinator.add(trigger)
+				more <- startTail(t, trigger, true)
+			}, log)
+		}
 
-			close(out)
-		}()
+		var out <-chan *cloudwatch.Event
+		switch {
+		case *ordered && *follow:
+			out = reorder(fanIn(sources, more, log), *orderedDelay)
+		case *ordered:
+			out = mergeSorted(sources)
+		default:
+			out = fanIn(sources, more, log)
+		}
+
+		printEvent := func(logEv *cloudwatch.Event) {
+			events := []*cloudwatch.Event{logEv}
+			if jqCode != nil {
+				events = applyJQ(jqCode, logEv)
+			}
+			for _, ev := range events {
+				msg, err := formatEvent(ev)
+				if err != nil {
+					exitWithError(err)
+				}
+				fmt.Println(msg)
+			}
+		}
 
 		for logEv := range out {
-			fmt.Println(formatLogMsg(*logEv, printTimestamp, printStreamName, printGroupName))
+			if contexts == nil || !logEv.Filtered {
+				printEvent(logEv)
+				continue
+			}
+			events, separator := contexts.hunk(logEv)
+			if separator && *output != "json" {
+				fmt.Println("--")
+			}
+			for _, ev := range events {
+				printEvent(ev)
+			}
 		}
 	}
 }
@@ -2,12 +2,21 @@ package main
 
 import (
 	//"fmt"
+	"bytes"
+	"errors"
+	"fmt"
 
 	"github.com/stretchr/testify/assert" //"reflect"
 	"io/ioutil"
 	"log"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/fatih/color"
+	"github.com/lucagrulla/cw/cloudwatch"
+	"github.com/lucagrulla/cw/cloudwatch/fake"
 )
 
 func TestTimestampToTime(t *testing.T) {
@@ -113,3 +122,513 @@ func TestCoordinatorRemoveItem(t *testing.T) {
 		a.Fail("Timeout")
 	}
 }
+
+func TestFormatJSON(t *testing.T) {
+	a := assert.New(t)
+	ev := &cloudwatch.Event{Group: "group",
+		Stream:        "stream",
+		EventID:       "42",
+		Time:          time.Date(2019, 1, 2, 10, 11, 12, 345000000, time.UTC),
+		IngestionTime: time.Date(2019, 1, 2, 10, 11, 13, 0, time.UTC),
+		Message:       `{"level":"info","msg":"<ok>"}`}
+
+	line, err := formatJSON(ev, time.UTC)
+	a.NoError(err)
+	a.Equal(`{"group":"group","stream":"stream","eventId":"42",`+
+		`"timestamp":"2019-01-02T10:11:12.345Z","timestampMs":1546423872345,`+
+		`"ingestionTime":"2019-01-02T10:11:13.000Z","ingestionTimeMs":1546423873000,`+
+		`"message":"{\"level\":\"info\",\"msg\":\"<ok>\"}"}`, line)
+
+	ev.Label = "api"
+	line, err = formatJSON(ev, time.UTC)
+	a.NoError(err)
+	a.True(strings.HasSuffix(line, `,"label":"api"}`))
+}
+
+func TestFormatTemplate(t *testing.T) {
+	a := assert.New(t)
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = true
+	ev := &cloudwatch.Event{Group: "group",
+		Stream:  "web-1",
+		Time:    time.Date(2019, 1, 2, 10, 11, 12, 345000000, time.UTC),
+		Message: `{"level":"error","request":{"path":"/orders","codes":[200,500]}}`}
+
+	tmpl, err := parseFormat(`{{.Time | tsfmt "15:04:05.000"}} [{{.Stream | pad 8}}] ` +
+		`{{field "level" .Message | upper | color "red"}} {{field "request.path" .Message}} ` +
+		`{{field "request.codes.1" .Message}} {{field "missing" .Message | pad -3}}|{{.Group | trunc 3}}`)
+	a.NoError(err)
+
+	var buf bytes.Buffer
+	a.NoError(tmpl.Execute(&buf, ev))
+	a.Equal("10:11:12.345 [web-1   ] ERROR /orders 500    |gro", buf.String())
+
+	tmpl, _ = parseFormat(`{{.Message | color "purple"}}`)
+	a.Error(tmpl.Execute(&buf, ev))
+}
+
+func TestPrettyJSON(t *testing.T) {
+	a := assert.New(t)
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = true
+
+	pretty, err := prettyJSON(`{"b":1,"a":{"list":[true,null,"x<y"],"empty":{}}}`)
+	a.NoError(err)
+	a.Equal("{\n  \"b\": 1,\n  \"a\": {\n    \"list\": [\n      true,\n      null,\n      \"x<y\"\n    ],\n    \"empty\": {}\n  }\n}", pretty)
+
+	prefix, doc, ok := splitJSON("2019-01-01T10:00:00Z\treq-1\t{\"level\":\"info\"}")
+	a.True(ok)
+	a.Equal("2019-01-01T10:00:00Z\treq-1\t", prefix)
+	a.Equal(`{"level":"info"}`, doc)
+
+	_, _, ok = splitJSON("plain {text")
+	a.False(ok)
+}
+
+func TestKeyValueJSON(t *testing.T) {
+	a := assert.New(t)
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = true
+
+	kv, err := keyValueJSON(`{"ts":1,"msg":"hello world","level":"info","ctx":{"id":2},"ok":true}`, []string{"level", "missing", "msg"})
+	a.NoError(err)
+	a.Equal(`level=info msg="hello world" ts=1 ctx={"id":2} ok=true`, kv)
+
+	_, err = keyValueJSON(`[1,2]`, nil)
+	a.Error(err)
+}
+
+func TestWhere(t *testing.T) {
+	a := assert.New(t)
+	msg := `{"level":"error","latency_ms":750,"request":{"path":"/orders/1","tags":["a","b"]},"ok":false,"überschrift":{"größe":"groß"},"名前":"値"}`
+
+	cases := map[string]bool{
+		`level == "error" && latency_ms > 500`:            true,
+		`level == "error" && latency_ms > 1000`:           false,
+		`level != "error" || request.path =~ "^/orders/"`: true,
+		`request.path !~ "orders"`:                        false,
+		`level in ["warn", "error"]`:                      true,
+		`"b" in request.tags && request.tags.0 == "a"`:    true,
+		`!(ok) && missing.field == null`:                  false,
+		`!ok && missing.field != "x"`:                     true,
+		`latency_ms >= 750 && latency_ms <= 750`:          true,
+		`"ord" in request.path && request.path < "/p"`:    true,
+		`missing`: false,
+		`(level == "info" || level == 'error') && !missing`: true,
+		`überschrift.größe == "groß" && 名前 == "値"`:          true,
+	}
+	for expr, expected := range cases {
+		filter, err := parseWhere(expr)
+		if a.NoError(err, expr) {
+			a.Equal(expected, filter(msg), expr)
+		}
+	}
+
+	filter, _ := parseWhere(`level == "error"`)
+	a.False(filter("ERROR plain text"))
+	a.True(filter(`2019-01-01T10:00:00Z req-1 {"level":"error"}`))
+
+	for _, expr := range []string{`level ==`, `level == "error`, `(a == 1`, `a =~ "("`, `a == 1 b`, `a # 1`} {
+		_, err := parseWhere(expr)
+		a.Error(err, expr)
+	}
+}
+
+func TestApplyJQ(t *testing.T) {
+	a := assert.New(t)
+	code, err := parseJQ(`.request | select(.status >= 500) | {path, status}, .path`)
+	a.NoError(err)
+
+	ev := &cloudwatch.Event{Group: "group", Message: `req-1 {"request":{"path":"/orders","status":502,"ip":"1.2.3.4"}}`}
+	var msgs []string
+	for _, e := range applyJQ(code, ev) {
+		a.Equal("group", e.Group)
+		msgs = append(msgs, e.Message)
+	}
+	a.Equal([]string{`req-1 {"path":"/orders","status":502}`, "req-1 /orders"}, msgs)
+
+	a.Empty(applyJQ(code, &cloudwatch.Event{Message: `{"request":{"path":"/orders","status":200}}`}))
+	a.Empty(applyJQ(code, &cloudwatch.Event{Message: `{"request":"not an object"}`}))
+	a.Equal([]*cloudwatch.Event{{Message: "plain text"}}, applyJQ(code, &cloudwatch.Event{Message: "plain text"}))
+
+	_, err = parseJQ(`.request |`)
+	a.Error(err)
+}
+
+func TestClientFilters(t *testing.T) {
+	a := assert.New(t)
+	defer func(r, ir []string, m, v, iv, w string) {
+		*regex, *iregex, *regexMatch, *grepv, *igrepv, *where = r, ir, m, v, iv, w
+	}(*regex, *iregex, *regexMatch, *grepv, *igrepv, *where)
+
+	accept := func(filters []cloudwatch.Filter, msg string) bool {
+		for _, f := range filters {
+			if !f(msg) {
+				return false
+			}
+		}
+		return true
+	}
+
+	*regex, *iregex, *regexMatch, *grepv, *igrepv = []string{`^GET `}, []string{`timeout|refused`}, "any", "", "healthcheck"
+	filters, includes, err := clientFilters()
+	a.NoError(err)
+	a.Len(includes, 2)
+	filters = append(filters, includeFilter(includes))
+	a.True(accept(filters, "GET /orders"))
+	a.True(accept(filters, "POST /orders: connection REFUSED"))
+	a.False(accept(filters, "POST /orders"))
+	a.False(accept(filters, "GET /HealthCheck"))
+
+	*regexMatch = "all"
+	filters, includes, _ = clientFilters()
+	filters = append(filters, includeFilter(includes))
+	a.False(accept(filters, "GET /orders"))
+	a.True(accept(filters, "GET /orders Timeout"))
+
+	*regex = []string{"("}
+	_, _, err = clientFilters()
+	a.Error(err)
+}
+
+func TestHighlight(t *testing.T) {
+	a := assert.New(t)
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = false
+
+	h := &highlighter{terms: []*regexp.Regexp{regexp.MustCompile("(?i)error"), regexp.MustCompile("order-[0-9]+")}}
+	a.Equal("\x1b[30;43mERROR\x1b[0m processing \x1b[30;46morder-42\x1b[0m: \x1b[30;43merror\x1b[0m",
+		h.highlight("ERROR processing order-42: error"))
+	a.Equal("\x1b[32m\"\x1b[30;43merror\x1b[0m\x1b[32m!\"\x1b[0m", h.highlight("\x1b[32m\"error!\"\x1b[0m"))
+
+	var none *highlighter
+	a.Equal("error", none.highlight("error"))
+
+	a.Equal([]string{"ERROR", "connection refused", "WARN"}, filterPatternTerms(`ERROR "connection refused" -healthcheck ?WARN`))
+	a.Empty(filterPatternTerms(`{ $.level = "error" }`))
+}
+
+func TestContextHunks(t *testing.T) {
+	a := assert.New(t)
+	base := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+	stream := make([]*cloudwatch.Event, 10)
+	for i := range stream {
+		stream[i] = &cloudwatch.Event{Group: "group", Stream: "web-1", Time: base.Add(time.Duration(i) * time.Second), Message: string(rune('a' + i))}
+	}
+	p := &contextPrinter{before: 1,
+		after:     1,
+		positions: make(map[string]*streamPosition),
+		stderr:    ioutil.Discard,
+		fetch: func(ev *cloudwatch.Event, before int, after int) ([]*cloudwatch.Event, []*cloudwatch.Event, error) {
+			i := int(ev.Time.Sub(base) / time.Second)
+			return stream[i-before : i], stream[i+1 : i+1+after], nil
+		}}
+	messages := func(events []*cloudwatch.Event, separator bool) []string {
+		var msgs []string
+		if separator {
+			msgs = append(msgs, "--")
+		}
+		for _, ev := range events {
+			msgs = append(msgs, ev.Message)
+		}
+		return msgs
+	}
+
+	a.Equal([]string{"b", "c", "d"}, messages(p.hunk(stream[2])))
+	a.Empty(messages(p.hunk(stream[3])), "already printed as context")
+	a.Equal([]string{"e", "f"}, messages(p.hunk(stream[4])), "overlapping hunks are merged")
+	a.Equal([]string{"--", "g", "h", "i"}, messages(p.hunk(stream[7])))
+
+	late := &cloudwatch.Event{Group: "group", Stream: "web-1", Time: base.Add(2500 * time.Millisecond), Message: "late"}
+	p.fetch = func(ev *cloudwatch.Event, before int, after int) ([]*cloudwatch.Event, []*cloudwatch.Event, error) {
+		return stream[2:3], stream[3:4], nil
+	}
+	a.Equal([]string{"--", "late"}, messages(p.hunk(late)), "late events are printed, their context only once")
+	a.Empty(messages(p.hunk(late)))
+
+	other := &cloudwatch.Event{Group: "group", Stream: "web-2", Time: base, Message: "x"}
+	p.fetch = func(ev *cloudwatch.Event, before int, after int) ([]*cloudwatch.Event, []*cloudwatch.Event, error) {
+		return nil, nil, errors.New("throttled")
+	}
+	a.Equal([]string{"--", "x"}, messages(p.hunk(other)))
+}
+
+func TestDetectLevel(t *testing.T) {
+	a := assert.New(t)
+	for msg, level := range map[string]int{
+		`{"level":"WARNING","msg":"disk"}`:                          levelWarn,
+		`{"Severity":"error"}`:                                      levelError,
+		`{"level":50,"msg":"pino"}`:                                 levelError,
+		"2019-01-01T10:00:00Z\treq-1\t{\"level\":\"debug\"}":        levelDebug,
+		`{"msg":"no level"}`:                                        levelUnknown,
+		"[ERROR]\t2019-01-01T10:00:00.000Z\treq-1\tboom":            levelError,
+		"2019-01-01T10:00:00.000Z\treq-1\tINFO\tstarted":            levelInfo,
+		"2019-01-01 10:00:00,123 WARN [main] slow":                  levelWarn,
+		"ERROR:root:failed":                                         levelError,
+		`time=2019-01-01T10:00:00Z level=fatal msg="out of memory"`: levelFatal,
+		"GET /orders 200 no error":                                  levelUnknown,
+		"START RequestId: 6c9b Version: $LATEST":                    levelUnknown,
+	} {
+		a.Equal(level, detectLevel(msg), msg)
+	}
+	for i := 0; i < 20; i++ { // level comes before severity whatever the map order
+		a.Equal(levelInfo, detectLevel(`{"severity":"ERROR","level":"info","lvl":"debug"}`))
+	}
+
+	filter := minLevelFilter(parseLevel("warn"))
+	a.True(filter("ERROR boom"))
+	a.False(filter(`{"level":"info"}`))
+	a.False(filter("no level at all"))
+
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = false
+	a.Equal("\x1b[31mfailed \x1b[30;43mhere\x1b[0m\x1b[31m!\x1b[0m", colorByLevel("failed \x1b[30;43mhere\x1b[0m!", levelError))
+	a.Equal("started", colorByLevel("started", levelInfo))
+}
+
+func TestPalette(t *testing.T) {
+	a := assert.New(t)
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = false
+
+	for _, spec := range []string{"basic", "256", "truecolor", "cyan,208,#5fafff"} {
+		p, err := parsePalette(spec)
+		if a.NoError(err, spec) {
+			a.Equal(p.sprint("/ecs/prod/web"), p.sprint("/ecs/prod/web"), spec)
+		}
+	}
+
+	p, _ := parsePalette("cyan,208,#5fafff")
+	a.Len(p, 3)
+	seen := map[string]bool{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		seen[p.colorFor(name).Sprint("x")] = true
+	}
+	a.True(len(seen) > 1, "names must be spread over the palette")
+	a.Equal("\x1b[38;5;208mx\x1b[0m", p[1].Sprint("x"))
+	a.Equal("\x1b[38;2;95;175;255mx\x1b[0m", p[2].Sprint("x"))
+
+	_, err := parsePalette("cyan,purple")
+	a.Error(err)
+	_, err = parsePalette("1,300")
+	a.Error(err)
+
+	timestamp := color.New(color.FgGreen).Sprint("x")
+	for _, c := range basicPalette() {
+		a.NotEqual(timestamp, c.Sprint("x"), "names can't look like timestamps")
+	}
+}
+
+func TestMergeOrdered(t *testing.T) {
+	a := assert.New(t)
+	base := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+	source := func(group string, seconds ...int) <-chan *cloudwatch.Event {
+		ch := make(chan *cloudwatch.Event)
+		go func() {
+			defer close(ch)
+			for _, s := range seconds {
+				ch <- &cloudwatch.Event{Group: group, Time: base.Add(time.Duration(s) * time.Second), Message: fmt.Sprintf("%s-%d", group, s)}
+			}
+		}()
+		return ch
+	}
+	messages := func(out <-chan *cloudwatch.Event) []string {
+		var msgs []string
+		for ev := range out {
+			msgs = append(msgs, ev.Message)
+		}
+		return msgs
+	}
+
+	merged := mergeSorted([]<-chan *cloudwatch.Event{source("a", 1, 4, 4, 9), source("b"), source("c", 2, 4, 10)})
+	a.Equal([]string{"a-1", "c-2", "a-4", "a-4", "c-4", "a-9", "c-10"}, messages(merged))
+
+	in := make(chan *cloudwatch.Event)
+	out := reorder(in, 50*time.Millisecond)
+	in <- &cloudwatch.Event{Time: base.Add(3 * time.Second), Message: "b-3"}
+	in <- &cloudwatch.Event{Time: base.Add(1 * time.Second), Message: "a-1"}
+	a.Equal("a-1", (<-out).Message)
+	a.Equal("b-3", (<-out).Message)
+
+	in <- &cloudwatch.Event{Time: base, Message: "late"}
+	in <- &cloudwatch.Event{Time: base.Add(5 * time.Second), Message: "c-5"}
+	close(in)
+	a.Equal([]string{"late", "c-5"}, messages(out))
+}
+
+func TestResolveTargets(t *testing.T) {
+	a := assert.New(t)
+	logs := fake.New()
+	for _, g := range []string{"/ecs/prod/payments-api", "/ecs/prod/payments-worker", "/ecs/prod/web", "/ecs/staging/web", "/aws/lambda/orders-create", "/aws/lambda/users"} {
+		logs.CreateLogGroup(g)
+	}
+	c := cloudwatch.NewWithClient(logs, log.New(ioutil.Discard, "", 0))
+
+	targets, selectors, err := resolveTargets([]string{"/ecs/prod/payments-*:web-", "/ecs/*/web", "exact:*", "/ecs/prod/web"},
+		[]string{"^/aws/lambda/orders-", "users$"}, c.LsGroups)
+	a.NoError(err)
+	a.Equal([]target{{group: "exact"},
+		{group: "/ecs/prod/web"},
+		{group: "/ecs/prod/payments-api", prefix: "web-"},
+		{group: "/ecs/prod/payments-worker", prefix: "web-"},
+		{group: "/ecs/staging/web"},
+		{group: "/aws/lambda/orders-create"},
+		{group: "/aws/lambda/users"}}, targets)
+	a.Len(selectors, 4)
+
+	_, _, err = resolveTargets(nil, []string{"("}, c.LsGroups)
+	a.Error(err)
+
+	targets, _, err = resolveTargets([]string{"web:~^web-[0-9]+$", "/ecs/staging/*:~sidecar"}, nil, c.LsGroups)
+	a.NoError(err)
+	a.Equal([]target{{group: "web", prefix: "web-", streamRegexp: regexp.MustCompile("^web-[0-9]+$")},
+		{group: "/ecs/staging/web", streamRegexp: regexp.MustCompile("sidecar")}}, targets)
+
+	_, _, err = resolveTargets([]string{"web:~("}, nil, c.LsGroups)
+	a.Error(err)
+
+	targets, _, err = resolveTargets([]string{"/ecs/prod/web;label=web", "/ecs/prod/web;label=web", "/ecs/prod/w*;label=web", "/ecs/prod/web;label=other"}, nil, c.LsGroups)
+	a.NoError(err)
+	a.Equal([]target{{group: "/ecs/prod/web", options: &targetOptions{label: "web"}},
+		{group: "/ecs/prod/web", options: &targetOptions{label: "other"}}}, targets)
+}
+
+func TestTargetOptions(t *testing.T) {
+	a := assert.New(t)
+	defer func(m string) { *regexMatch = m }(*regexMatch)
+	*regexMatch = "any"
+
+	targets, _, err := parseTargets([]string{"api:web-;filter=ERROR;regex=timeout;regex=refused;grepv=retry;exclude-stream=healthcheck;label=API",
+		"worker", "/ecs/*;label=ecs"}, nil)
+	a.NoError(err)
+	res := func(exprs ...string) []*regexp.Regexp {
+		var res []*regexp.Regexp
+		for _, expr := range exprs {
+			res = append(res, regexp.MustCompile(expr))
+		}
+		return res
+	}
+	a.Equal([]target{{group: "api", prefix: "web-", options: &targetOptions{filterPattern: "ERROR",
+		regexps:        res("timeout", "refused"),
+		grepv:          res("retry"),
+		excludeStreams: res("healthcheck"),
+		label:          "API"}},
+		{group: "worker"}}, targets)
+	a.True(targetsFiltered(targets))
+
+	opts := &cloudwatch.TailOptions{FilterPattern: "global", Filters: []cloudwatch.Filter{cloudwatch.ExcludeMatching(regexp.MustCompile("debug"))}}
+	targets[0].options.apply(opts, nil)
+	a.Equal("ERROR", opts.FilterPattern)
+	a.Equal("API", opts.Label)
+	a.Len(opts.ExcludeStreams, 1)
+	accept := func(message string) bool {
+		for _, f := range opts.Filters {
+			if !f(message) {
+				return false
+			}
+		}
+		return true
+	}
+	a.True(accept("connection refused"))
+	a.False(accept("connection refused, retry"))
+	a.False(accept("debug: timeout"))
+	a.False(accept("ok"))
+
+	opts = &cloudwatch.TailOptions{}
+	targets[0].options.apply(opts, res("^GET "))
+	a.True(accept("GET /orders"))
+	a.True(accept("POST /orders: connection refused"))
+	a.False(accept("POST /orders"))
+
+	*regexMatch = "all"
+	opts = &cloudwatch.TailOptions{}
+	targets[0].options.apply(opts, res("^GET "))
+	a.False(accept("GET /orders: timeout"))
+	a.True(accept("GET /orders: timeout, connection refused"))
+
+	opts = &cloudwatch.TailOptions{FilterPattern: "global"}
+	targets[1].options.apply(opts, res("^GET "))
+	a.Equal("global", opts.FilterPattern)
+	a.True(accept("GET /orders"))
+	a.False(accept("POST /orders"))
+	a.False(targetsFiltered(targets[1:]))
+
+	for _, arg := range []string{"api;filter", "api;color=red", "api;regex=(", "api;regex=a;b"} {
+		_, _, err = parseTargets([]string{arg}, nil)
+		a.Error(err, arg)
+	}
+
+	targets, _, err = parseTargets([]string{`api:~^a\;b;regex=x\;y;label=a\;b`}, nil)
+	a.NoError(err)
+	a.Equal([]target{{group: "api", prefix: "a;b", streamRegexp: regexp.MustCompile("^a;b"), options: &targetOptions{regexps: res("x;y"), label: "a;b"}}}, targets)
+}
+
+func TestDiscoverTargets(t *testing.T) {
+	a := assert.New(t)
+	logs := fake.New()
+	logs.CreateLogGroup("/aws/lambda/orders-create")
+	c := cloudwatch.NewWithClient(logs, log.New(ioutil.Discard, "", 0))
+
+	targets, selectors, err := resolveTargets([]string{"/aws/lambda/orders-*"}, nil, c.LsGroups)
+	a.NoError(err)
+
+	started := make(chan target, 10)
+	go discoverTargets(selectors, targets, 10*time.Millisecond, c.LsGroups, func(t target) { started <- t },
+		log.New(ioutil.Discard, "", 0))
+
+	logs.CreateLogGroup("/aws/lambda/orders-cancel")
+	logs.CreateLogGroup("/aws/lambda/users")
+	select {
+	case t := <-started:
+		a.Equal(target{group: "/aws/lambda/orders-cancel"}, t)
+	case <-time.After(1 * time.Second):
+		a.Fail("Timeout")
+	}
+	select {
+	case t := <-started:
+		a.Fail("Started twice or a group not matching", t.group)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCoordinatorAdd(t *testing.T) {
+	a := assert.New(t)
+	log := log.New(ioutil.Discard, "", log.LstdFlags)
+
+	coordinator := &tailCoordinator{log: log, discovery: true}
+	coordinator.start(nil)
+
+	groupTrigger1 := make(chan time.Time, 1)
+	groupTrigger2 := make(chan time.Time, 1)
+	coordinator.add(groupTrigger1)
+	coordinator.add(groupTrigger2)
+
+	for _, trigger := range []chan time.Time{groupTrigger1, groupTrigger2} {
+		select {
+		case <-trigger:
+		case <-time.After(1 * time.Second):
+			a.Fail("Timeout")
+		}
+	}
+}
+
+func TestFanInMore(t *testing.T) {
+	a := assert.New(t)
+	source := make(chan *cloudwatch.Event, 1)
+	added := make(chan *cloudwatch.Event, 1)
+	more := make(chan (<-chan *cloudwatch.Event))
+	out := fanIn([]<-chan *cloudwatch.Event{source}, more, log.New(ioutil.Discard, "", 0))
+
+	source <- &cloudwatch.Event{Message: "first"}
+	close(source)
+	a.Equal("first", (<-out).Message)
+
+	more <- added
+	added <- &cloudwatch.Event{Message: "added"}
+	close(added)
+	a.Equal("added", (<-out).Message)
+
+	close(more)
+	_, ok := <-out
+	a.False(ok)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"container/heap"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+// fanIn forwards the events of all the sources to a single channel, in arrival order.
+// Sources received from more, if not nil, are forwarded too.
+// The channel is closed once all the sources are closed and more, if not nil, is closed.
+func fanIn(sources []<-chan *cloudwatch.Event, more <-chan (<-chan *cloudwatch.Event), log *log.Logger) <-chan *cloudwatch.Event {
+	out := make(chan *cloudwatch.Event)
+	var wg sync.WaitGroup
+	forward := func(source <-chan *cloudwatch.Event) {
+		defer wg.Done()
+		for ev := range source {
+			out <- ev
+		}
+	}
+	wg.Add(len(sources))
+	for _, source := range sources {
+		go forward(source)
+	}
+	if more != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for source := range more {
+				wg.Add(1)
+				go forward(source)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		log.Println("closing main channel...")
+		close(out)
+	}()
+	return out
+}
+
+type heapItem struct {
+	ev      *cloudwatch.Event
+	source  int
+	seq     int64
+	arrival time.Time
+}
+
+// eventHeap is a min heap of events by time; events with the same time are sorted by source, then by arrival.
+type eventHeap []*heapItem
+
+func (h eventHeap) Len() int { return len(h) }
+
+func (h eventHeap) Less(i, j int) bool {
+	if h[i].ev.Time.Equal(h[j].ev.Time) {
+		if h[i].source != h[j].source {
+			return h[i].source < h[j].source
+		}
+		return h[i].seq < h[j].seq
+	}
+	return h[i].ev.Time.Before(h[j].ev.Time)
+}
+
+func (h eventHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *eventHeap) Push(x interface{}) { *h = append(*h, x.(*heapItem)) }
+
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// mergeSorted k-way merges sources, each one sorted by time, into a single channel sorted by time.
+// An event is published only once every open source has an event to compare it with,
+// so a slow source holds back the others. The channel is closed once all the sources are closed.
+func mergeSorted(sources []<-chan *cloudwatch.Event) <-chan *cloudwatch.Event {
+	out := make(chan *cloudwatch.Event)
+	go func() {
+		defer close(out)
+		h := &eventHeap{}
+		var seq int64
+		next := func(source int) {
+			if ev, ok := <-sources[source]; ok {
+				seq++
+				heap.Push(h, &heapItem{ev: ev, source: source, seq: seq})
+			}
+		}
+		for i := range sources {
+			next(i)
+		}
+		for h.Len() > 0 {
+			item := heap.Pop(h).(*heapItem)
+			out <- item.ev
+			next(item.source)
+		}
+	}()
+	return out
+}
+
+// reorder holds the events for delay after their arrival and publishes them sorted by time,
+// so that events arriving up to delay apart, i.e. from different groups, are published in order.
+// Events arriving later than that are published as soon as possible. The channel is closed once in is closed.
+func reorder(in <-chan *cloudwatch.Event, delay time.Duration) <-chan *cloudwatch.Event {
+	out := make(chan *cloudwatch.Event)
+	go func() {
+		defer close(out)
+		h := &eventHeap{}
+		var seq int64
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		for {
+			for h.Len() > 0 && time.Since((*h)[0].arrival) >= delay {
+				out <- heap.Pop(h).(*heapItem).ev
+			}
+			var wait <-chan time.Time
+			if h.Len() > 0 {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(delay - time.Since((*h)[0].arrival))
+				wait = timer.C
+			}
+
+			select {
+			case ev, ok := <-in:
+				if !ok {
+					for h.Len() > 0 {
+						out <- heap.Pop(h).(*heapItem).ev
+					}
+					return
+				}
+				seq++
+				heap.Push(h, &heapItem{ev: ev, seq: seq, arrival: time.Now()})
+			case <-wait:
+			}
+		}
+	}()
+	return out
+}
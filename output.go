@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+const jsonTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// jsonEvent is the JSON Lines representation of an event.
+type jsonEvent struct {
+	Group               string `json:"group"`
+	Stream              string `json:"stream"`
+	EventID             string `json:"eventId"`
+	Timestamp           string `json:"timestamp"`
+	TimestampMillis     int64  `json:"timestampMs"`
+	IngestionTime       string `json:"ingestionTime"`
+	IngestionTimeMillis int64  `json:"ingestionTimeMs"`
+	Message             string `json:"message"`
+	Profile             string `json:"profile,omitempty"`
+	Region              string `json:"region,omitempty"`
+	Label               string `json:"label,omitempty"`
+}
+
+// formatTemplate is the parsed --format template, if any.
+var formatTemplate *template.Template
+
+// formatEvent renders the event according to the --output and --format flags.
+func formatEvent(ev *cloudwatch.Event) (string, error) {
+	if *output == "json" {
+		return formatJSON(ev, zone())
+	}
+	if formatTemplate != nil {
+		var buf bytes.Buffer
+		if err := formatTemplate.Execute(&buf, ev); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	return formatLogMsg(ev, printTimestamp, printStreamName, printGroupName), nil
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// formatJSON renders the event as a single line JSON object, with times in RFC3339 format in the given zone.
+func formatJSON(ev *cloudwatch.Event, zone *time.Location) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(jsonEvent{Group: ev.Group,
+		Stream:              ev.Stream,
+		EventID:             ev.EventID,
+		Timestamp:           ev.Time.In(zone).Format(jsonTimeFormat),
+		TimestampMillis:     toMillis(ev.Time),
+		IngestionTime:       ev.IngestionTime.In(zone).Format(jsonTimeFormat),
+		IngestionTimeMillis: toMillis(ev.IngestionTime),
+		Message:             ev.Message,
+		Profile:             ev.Profile,
+		Region:              ev.Region,
+		Label:               ev.Label})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// palette colours group and stream names: a name always gets the same colour, across runs too.
+type palette []*color.Color
+
+// colorFor returns the colour of a name, picked by hashing it.
+func (p palette) colorFor(name string) *color.Color {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return p[h.Sum32()%uint32(len(p))]
+}
+
+// sprint colours a name with its colour.
+func (p palette) sprint(name string) string {
+	return p.colorFor(name).Sprint(name)
+}
+
+var namesPalette = basicPalette()
+
+// basicPalette uses the 16 standard terminal colours, leaving out the ones used for levels, highlighting and timestamps.
+func basicPalette() palette {
+	return palette{
+		color.New(color.FgCyan),
+		color.New(color.FgBlue),
+		color.New(color.FgMagenta),
+		color.New(color.FgHiCyan),
+		color.New(color.FgHiBlue),
+		color.New(color.FgHiMagenta),
+		color.New(color.FgHiGreen),
+	}
+}
+
+// palette256 uses the bright enough colours of the 6x6x6 cube of 256 colours terminals, leaving out the reds.
+func palette256() palette {
+	var p palette
+	for r := 0; r < 6; r++ {
+		for g := 0; g < 6; g++ {
+			for b := 0; b < 6; b++ {
+				if r+g+b < 6 || r >= 4 && g <= 2 && b <= 2 || r == g && g == b {
+					continue
+				}
+				p = append(p, color256(16+36*r+6*g+b))
+			}
+		}
+	}
+	return p
+}
+
+// paletteTrueColor spreads 64 colours evenly over the hue circle, with the same saturation and lightness.
+func paletteTrueColor() palette {
+	var p palette
+	for i := 0; i < 64; i++ {
+		r, g, b := hslToRGB(float64(i)*360/64, 0.65, 0.6)
+		p = append(p, trueColor(r, g, b))
+	}
+	return p
+}
+
+func color256(n int) *color.Color {
+	return color.New(38, 5, color.Attribute(n))
+}
+
+func trueColor(r, g, b int) *color.Color {
+	return color.New(38, 2, color.Attribute(r), color.Attribute(g), color.Attribute(b))
+}
+
+func hslToRGB(h, s, l float64) (int, int, int) {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return int(math.Round((r + m) * 255)), int(math.Round((g + m) * 255)), int(math.Round((b + m) * 255))
+}
+
+// parsePalette parses a --palette value: basic, 256, truecolor or a comma separated list of colours,
+// each one either a colour name, a 256 colours index or a #rrggbb true colour.
+func parsePalette(spec string) (palette, error) {
+	switch spec {
+	case "", "basic":
+		return basicPalette(), nil
+	case "256":
+		return palette256(), nil
+	case "truecolor":
+		return paletteTrueColor(), nil
+	}
+
+	var p palette
+	for _, c := range strings.Split(spec, ",") {
+		c = strings.TrimSpace(c)
+		if attr, ok := colors[c]; ok {
+			p = append(p, color.New(attr))
+			continue
+		}
+		if n, err := strconv.Atoi(c); err == nil && n >= 0 && n < 256 {
+			p = append(p, color256(n))
+			continue
+		}
+		if len(c) == 7 && c[0] == '#' {
+			if rgb, err := strconv.ParseUint(c[1:], 16, 32); err == nil {
+				p = append(p, trueColor(int(rgb>>16), int(rgb>>8&0xff), int(rgb&0xff)))
+				continue
+			}
+		}
+		return nil, fmt.Errorf("unknown color %q", c)
+	}
+	return p, nil
+}
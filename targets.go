@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+// target is a log group to tail, with the prefix of the streams to tail; an empty prefix selects all the streams.
+// streamRegexp, when set, is the regular expression the names of the streams to tail must match as well.
+type target struct {
+	group        string
+	prefix       string
+	streamRegexp *regexp.Regexp
+	options      *targetOptions
+}
+
+// targetKey identifies a target by value, options included: regular expressions are compared by their source.
+type targetKey struct {
+	group          string
+	prefix         string
+	streamRegexp   string
+	filterPattern  string
+	regexps        string
+	grepv          string
+	excludeStreams string
+	label          string
+}
+
+// exprs joins the sources of regular expressions.
+func exprs(res []*regexp.Regexp) string {
+	sources := make([]string, len(res))
+	for i, re := range res {
+		sources[i] = re.String()
+	}
+	return strings.Join(sources, "\x00")
+}
+
+func (t target) key() targetKey {
+	k := targetKey{group: t.group, prefix: t.prefix}
+	if t.streamRegexp != nil {
+		k.streamRegexp = t.streamRegexp.String()
+	}
+	if o := t.options; o != nil {
+		k.filterPattern = o.filterPattern
+		k.regexps = exprs(o.regexps)
+		k.grepv = exprs(o.grepv)
+		k.excludeStreams = exprs(o.excludeStreams)
+		k.label = o.label
+	}
+	return k
+}
+
+// targetOptions are the options given to a single target as ;key=value pairs, i.e. 'api;filter=ERROR;label=api'.
+// The filter pattern replaces --filter-pattern, the other options add to the tail flags.
+type targetOptions struct {
+	filterPattern  string
+	regexps        []*regexp.Regexp
+	grepv          []*regexp.Regexp
+	excludeStreams []*regexp.Regexp
+	label          string
+}
+
+// splitEscaped splits a target argument on ;, except on the \; escaping a ; inside a value, which are unescaped.
+func splitEscaped(arg string) []string {
+	var tokens []string
+	var token strings.Builder
+	for i := 0; i < len(arg); i++ {
+		switch {
+		case arg[i] == '\\' && i+1 < len(arg) && arg[i+1] == ';':
+			token.WriteByte(';')
+			i++
+		case arg[i] == ';':
+			tokens = append(tokens, token.String())
+			token.Reset()
+		default:
+			token.WriteByte(arg[i])
+		}
+	}
+	return append(tokens, token.String())
+}
+
+// splitOptions splits the ;key=value options from a target argument. A ; inside a value is escaped as \;.
+func splitOptions(arg string) (string, *targetOptions, error) {
+	tokens := splitEscaped(arg)
+	if len(tokens) == 1 {
+		return tokens[0], nil, nil
+	}
+	opts := &targetOptions{}
+	for _, option := range tokens[1:] {
+		kv := strings.SplitN(option, "=", 2)
+		if len(kv) != 2 {
+			return "", nil, fmt.Errorf("can't parse %s in %s: target options are key=value pairs, escape a ; inside a value as \\;", option, arg)
+		}
+		key, value := kv[0], kv[1]
+		switch key {
+		case "filter":
+			opts.filterPattern = value
+		case "label":
+			opts.label = value
+		case "regex", "grepv", "exclude-stream":
+			res, err := compileRegexps([]string{value}, false)
+			if err != nil {
+				return "", nil, err
+			}
+			switch key {
+			case "regex":
+				opts.regexps = append(opts.regexps, res...)
+			case "grepv":
+				opts.grepv = append(opts.grepv, res...)
+			default:
+				opts.excludeStreams = append(opts.excludeStreams, res...)
+			}
+		default:
+			return "", nil, fmt.Errorf("unknown option %s in %s, use filter, regex, grepv, exclude-stream or label", key, arg)
+		}
+	}
+	return tokens[0], opts, nil
+}
+
+// filtered returns true when the options filter the events.
+func (o *targetOptions) filtered() bool {
+	return o != nil && (o.filterPattern != "" || len(o.regexps) > 0 || len(o.grepv) > 0)
+}
+
+// targetsFiltered returns true when the options of any of the targets filter the events.
+func targetsFiltered(targets []target) bool {
+	for _, t := range targets {
+		if t.options.filtered() {
+			return true
+		}
+	}
+	return false
+}
+
+// apply applies the options to the tail options built from the tail flags, adding the filter of the --regex expressions:
+// the regex options are combined with them, so a message matching any of them is accepted unless --regex-match is all.
+func (o *targetOptions) apply(opts *cloudwatch.TailOptions, includes []*regexp.Regexp) {
+	filters := append([]cloudwatch.Filter{}, opts.Filters...)
+	if o != nil {
+		includes = append(append([]*regexp.Regexp{}, includes...), o.regexps...)
+		for _, re := range o.grepv {
+			filters = append(filters, cloudwatch.ExcludeMatching(re))
+		}
+	}
+	if len(includes) > 0 {
+		filters = append(filters, includeFilter(includes))
+	}
+	opts.Filters = filters
+	if o == nil {
+		return
+	}
+
+	if o.filterPattern != "" {
+		opts.FilterPattern = o.filterPattern
+	}
+	opts.ExcludeStreams = append(append([]*regexp.Regexp{}, opts.ExcludeStreams...), o.excludeStreams...)
+	opts.Label = o.label
+}
+
+// groupSelector selects log groups by name.
+// prefix is the literal beginning shared by all the names it selects, used to narrow the listing server side.
+// streams is the stream selection of the targets it selects, without the group.
+type groupSelector struct {
+	pattern string
+	prefix  string
+	re      *regexp.Regexp
+	streams target
+}
+
+// isGlob returns true if the group name contains glob wildcards.
+func isGlob(group string) bool {
+	return strings.ContainsAny(group, "*?")
+}
+
+// globSelector compiles a glob: * matches any sequence of characters, / included, and ? any single character.
+func globSelector(glob string, streams target) groupSelector {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, c := range glob {
+		switch c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return groupSelector{pattern: glob,
+		prefix:  glob[:strings.IndexAny(glob, "*?")],
+		re:      regexp.MustCompile(expr.String()),
+		streams: streams}
+}
+
+// regexSelector compiles a --group-regex. Only anchored expressions, i.e. ^/aws/lambda/, are narrowed server side.
+func regexSelector(expr string, streams target) (groupSelector, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return groupSelector{}, fmt.Errorf("can't parse %s as a valid regular expression", expr)
+	}
+	s := groupSelector{pattern: expr, re: re, streams: streams}
+	if strings.HasPrefix(expr, "^") {
+		s.prefix, _ = re.LiteralPrefix()
+	}
+	return s, nil
+}
+
+// splitTarget splits a groupName[:logStreamPrefix] argument. * as prefix selects all the streams.
+func splitTarget(groupStream string) (string, string) {
+	tokens := strings.SplitN(groupStream, ":", 2)
+	if len(tokens) > 1 && tokens[1] != "*" {
+		return tokens[0], tokens[1]
+	}
+	return tokens[0], ""
+}
+
+// parseStreams parses the stream part of a target: a prefix, or ~ followed by a regular expression, i.e. ~^web-[0-9]+$.
+// Anchored expressions are narrowed down by their literal prefix when listing the streams.
+func parseStreams(streams string) (target, error) {
+	if !strings.HasPrefix(streams, "~") {
+		return target{prefix: streams}, nil
+	}
+	expr := streams[1:]
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return target{}, fmt.Errorf("can't parse %s as a valid regular expression", expr)
+	}
+	t := target{streamRegexp: re}
+	if strings.HasPrefix(expr, "^") {
+		t.prefix, _ = re.LiteralPrefix()
+	}
+	return t, nil
+}
+
+// parseTargets splits the tail arguments and the --group-regex expressions into the log groups named explicitly
+// and the selectors of log groups to resolve listing the log groups.
+func parseTargets(args []string, groupRegexps []string) ([]target, []groupSelector, error) {
+	var targets []target
+	var selectors []groupSelector
+	for _, arg := range args {
+		groupStream, options, err := splitOptions(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		group, spec := splitTarget(groupStream)
+		streams, err := parseStreams(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		streams.options = options
+		if isGlob(group) {
+			selectors = append(selectors, globSelector(group, streams))
+			continue
+		}
+		streams.group = group
+		targets = append(targets, streams)
+	}
+	for _, expr := range groupRegexps {
+		s, err := regexSelector(expr, target{})
+		if err != nil {
+			return nil, nil, err
+		}
+		selectors = append(selectors, s)
+	}
+	return targets, selectors, nil
+}
+
+// resolve lists the log groups selected by s.
+func (s groupSelector) resolve(lsGroups func(prefix *string) (<-chan *string, <-chan error)) ([]target, error) {
+	var targets []target
+	groups, errc := lsGroups(&s.prefix)
+	for group := range groups {
+		if s.re.MatchString(*group) {
+			t := s.streams
+			t.group = *group
+			targets = append(targets, t)
+		}
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// resolveTargets turns the tail arguments and the --group-regex expressions into the log groups to tail,
+// and returns the selectors to resolve again to discover new log groups.
+// A group selected more than once with the same streams and options is tailed once.
+func resolveTargets(args []string, groupRegexps []string, lsGroups func(prefix *string) (<-chan *string, <-chan error)) ([]target, []groupSelector, error) {
+	named, selectors, err := parseTargets(args, groupRegexps)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var targets []target
+	seen := make(map[targetKey]bool)
+	add := func(ts []target) {
+		for _, t := range ts {
+			if !seen[t.key()] {
+				seen[t.key()] = true
+				targets = append(targets, t)
+			}
+		}
+	}
+	add(named)
+	for _, s := range selectors {
+		selected, err := s.resolve(lsGroups)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(selected) == 0 {
+			fmt.Fprintf(os.Stderr, "No log group matches %s.\n", s.pattern)
+		}
+		add(selected)
+	}
+	return targets, selectors, nil
+}
+
+// discoverTargets resolves the selectors again every interval and starts the targets not known yet,
+// i.e. the log groups of services deployed after the tail started. Listing errors are logged and retried at the next interval.
+// It never returns.
+func discoverTargets(selectors []groupSelector, known []target, interval time.Duration,
+	lsGroups func(prefix *string) (<-chan *string, <-chan error), start func(target), log *log.Logger) {
+	seen := make(map[targetKey]bool)
+	for _, t := range known {
+		seen[t.key()] = true
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, s := range selectors {
+			selected, err := s.resolve(lsGroups)
+			if err != nil {
+				log.Printf("discovery: can't list the log groups matching %s: %s\n", s.pattern, err)
+				continue
+			}
+			for _, t := range selected {
+				if !seen[t.key()] {
+					seen[t.key()] = true
+					log.Printf("discovery: new log group %s\n", t.group)
+					start(t)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+var colors = map[string]color.Attribute{
+	"black":   color.FgBlack,
+	"red":     color.FgRed,
+	"green":   color.FgGreen,
+	"yellow":  color.FgYellow,
+	"blue":    color.FgBlue,
+	"magenta": color.FgMagenta,
+	"cyan":    color.FgCyan,
+	"white":   color.FgWhite,
+	"bold":    color.Bold,
+	"faint":   color.Faint,
+}
+
+// templateFuncs are the helper functions available to --format templates.
+// The value to act on is always the last argument so that helpers can be used in pipelines,
+// i.e. {{.Stream | pad 20 | color "blue"}}.
+var templateFuncs = template.FuncMap{
+	"tsfmt": func(layout string, t time.Time) string {
+		return t.In(zone()).Format(layout)
+	},
+	"color": func(name string, s string) (string, error) {
+		attr, ok := colors[name]
+		if !ok {
+			return "", fmt.Errorf("unknown color %q", name)
+		}
+		return color.New(attr).Sprint(s), nil
+	},
+	"hashcolor": func(s string) string {
+		return namesPalette.sprint(s)
+	},
+	"pad": func(width int, s string) string {
+		return pad(width, s)
+	},
+	"trunc": func(width int, s string) string {
+		if utf8.RuneCountInString(s) <= width {
+			return s
+		}
+		return string([]rune(s)[:width])
+	},
+	"highlight": func(s string) string {
+		return messageHighlighter.highlight(s)
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"field": func(path string, message string) string {
+		var v interface{}
+		if err := json.Unmarshal([]byte(message), &v); err != nil {
+			return ""
+		}
+		field, ok := lookupField(v, path)
+		if !ok {
+			return ""
+		}
+		return fieldString(field)
+	},
+	"level": func(message string) string {
+		return levelName(detectLevel(message))
+	},
+}
+
+// pad pads s with spaces up to width runes: on the right for a positive width, on the left for a negative one.
+func pad(width int, s string) string {
+	left := width < 0
+	if left {
+		width = -width
+	}
+	n := width - utf8.RuneCountInString(s)
+	if n <= 0 {
+		return s
+	}
+	if left {
+		return strings.Repeat(" ", n) + s
+	}
+	return s + strings.Repeat(" ", n)
+}
+
+// lookupField walks a decoded JSON value following a dot separated path, i.e. request.headers.0.
+// Numeric path segments index arrays.
+func lookupField(v interface{}, path string) (interface{}, bool) {
+	if path == "" || path == "." {
+		return v, true
+	}
+	for _, key := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			field, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			v = field
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			v = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// fieldString renders a decoded JSON value: strings as they are, anything else as JSON.
+func fieldString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// parseFormat parses a --format template.
+func parseFormat(format string) (*template.Template, error) {
+	return template.New("format").Funcs(templateFuncs).Parse(format)
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A --where expression is evaluated against the JSON document of each message.
+//
+//	expr       := or
+//	or         := and ( "||" and )*
+//	and        := unary ( "&&" unary )*
+//	unary      := "!" unary | comparison
+//	comparison := operand [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" | "=~" | "!~" | "in" ) operand ]
+//	operand    := path | string | number | "true" | "false" | "null" | "[" [ operand ( "," operand )* ] "]" | "(" expr ")"
+//
+// A path is a dot separated list of keys, i.e. request.headers.user-agent; numeric keys index arrays.
+// in tests the membership of a value in a list, or of a substring in a string; =~ and !~ match regular expressions.
+// Missing fields are never equal to any value. A path on its own is true when the field exists and isn't false, null, 0 or "".
+
+type whereNode interface {
+	eval(doc interface{}) interface{}
+}
+
+// missing is the value of a path not found in the document.
+type missing struct{}
+
+type literalNode struct{ value interface{} }
+
+type pathNode struct{ keys []string }
+
+type listNode struct{ items []whereNode }
+
+type notNode struct{ operand whereNode }
+
+type logicalNode struct {
+	and         bool
+	left, right whereNode
+}
+
+type compareNode struct {
+	op          string
+	left, right whereNode
+}
+
+type matchNode struct {
+	negate  bool
+	operand whereNode
+	re      *regexp.Regexp
+}
+
+func (n literalNode) eval(doc interface{}) interface{} { return n.value }
+
+func (n pathNode) eval(doc interface{}) interface{} {
+	v, ok := lookupField(doc, strings.Join(n.keys, "."))
+	if !ok {
+		return missing{}
+	}
+	return v
+}
+
+func (n listNode) eval(doc interface{}) interface{} {
+	items := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		items[i] = item.eval(doc)
+	}
+	return items
+}
+
+func (n notNode) eval(doc interface{}) interface{} { return !truthy(n.operand.eval(doc)) }
+
+func (n logicalNode) eval(doc interface{}) interface{} {
+	left := truthy(n.left.eval(doc))
+	if n.and {
+		return left && truthy(n.right.eval(doc))
+	}
+	return left || truthy(n.right.eval(doc))
+}
+
+func (n compareNode) eval(doc interface{}) interface{} {
+	left, right := n.left.eval(doc), n.right.eval(doc)
+	switch n.op {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	case "in":
+		items, ok := right.([]interface{})
+		if !ok {
+			if s, isString := right.(string); isString {
+				l, leftIsString := left.(string)
+				return leftIsString && strings.Contains(s, l)
+			}
+			return false
+		}
+		for _, item := range items {
+			if equal(left, item) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if l, ok := left.(float64); ok {
+		if r, ok := right.(float64); ok {
+			return compareOrdered(n.op, l < r, l == r)
+		}
+	}
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			return compareOrdered(n.op, l < r, l == r)
+		}
+	}
+	return false
+}
+
+func (n matchNode) eval(doc interface{}) interface{} {
+	var s string
+	switch v := n.operand.eval(doc).(type) {
+	case missing:
+		return n.negate
+	case string:
+		s = v
+	default:
+		s = fieldString(v)
+	}
+	return n.re.MatchString(s) != n.negate
+}
+
+func compareOrdered(op string, less bool, equal bool) bool {
+	switch op {
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	default:
+		return !less
+	}
+}
+
+func equal(left interface{}, right interface{}) bool {
+	switch l := left.(type) {
+	case missing:
+		return false
+	case float64, string, bool, nil:
+		return l == right
+	default:
+		if _, ok := right.(missing); ok {
+			return false
+		}
+		lb, _ := json.Marshal(l)
+		rb, _ := json.Marshal(right)
+		return string(lb) == string(rb)
+	}
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case missing, nil:
+		return false
+	case bool:
+		return t
+	case float64:
+		return t != 0
+	case string:
+		return t != ""
+	default:
+		return true
+	}
+}
+
+var comparisonOps = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+func isPathChar(c rune) bool {
+	return c == '_' || c == '.' || c == '-' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+type whereToken struct {
+	kind  string // op, string, number, ident
+	text  string
+	value interface{}
+	pos   int
+}
+
+func lexWhere(expr string) ([]whereToken, error) {
+	var tokens []whereToken
+	ops := []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", "[", "]", ","}
+	i := 0
+	for i < len(expr) {
+		c, size := utf8.DecodeRuneInString(expr[i:])
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+		case c == '"' || c == '\'':
+			end := i + 1
+			var sb strings.Builder
+			for ; end < len(expr) && rune(expr[end]) != c; end++ {
+				if expr[end] == '\\' && end+1 < len(expr) {
+					end++
+					switch expr[end] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(expr[end])
+					}
+					continue
+				}
+				sb.WriteByte(expr[end])
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, whereToken{kind: "string", text: expr[i : end+1], value: sb.String(), pos: i})
+			i = end + 1
+		case c == '-' || c >= '0' && c <= '9':
+			end := i + 1
+			for end < len(expr) && strings.ContainsRune("0123456789.eE+-", rune(expr[end])) {
+				end++
+			}
+			n, err := strconv.ParseFloat(expr[i:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", expr[i:end], i)
+			}
+			tokens = append(tokens, whereToken{kind: "number", text: expr[i:end], value: n, pos: i})
+			i = end
+		case c == '_' || c == '.' || unicode.IsLetter(c):
+			end := i
+			for end < len(expr) {
+				r, n := utf8.DecodeRuneInString(expr[end:])
+				if !isPathChar(r) {
+					break
+				}
+				end += n
+			}
+			tokens = append(tokens, whereToken{kind: "ident", text: expr[i:end], pos: i})
+			i = end
+		default:
+			matched := false
+			for _, op := range ops {
+				if strings.HasPrefix(expr[i:], op) {
+					tokens = append(tokens, whereToken{kind: "op", text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+func (p *whereParser) peek() *whereToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *whereParser) accept(kind string, text string) bool {
+	if t := p.peek(); t != nil && t.kind == kind && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *whereParser) errorf(format string, args ...interface{}) error {
+	where := "at the end of the expression"
+	if t := p.peek(); t != nil {
+		where = fmt.Sprintf("at position %d", t.pos)
+	}
+	return fmt.Errorf(format+" "+where, args...)
+}
+
+func (p *whereParser) parseOr() (whereNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("op", "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseAnd() (whereNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("op", "&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseUnary() (whereNode, error) {
+	if p.accept("op", "!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *whereParser) parseComparison() (whereNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t == nil {
+		return left, nil
+	}
+	switch {
+	case t.kind == "op" && (t.text == "=~" || t.text == "!~"):
+		p.pos++
+		pattern := p.peek()
+		if pattern == nil || pattern.kind != "string" {
+			return nil, p.errorf("expected a regular expression string")
+		}
+		p.pos++
+		re, err := regexp.Compile(pattern.value.(string))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression at position %d: %s", pattern.pos, err)
+		}
+		return matchNode{negate: t.text == "!~", operand: left, re: re}, nil
+	case t.kind == "op" && comparisonOps[t.text], t.kind == "ident" && t.text == "in":
+		p.pos++
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{op: t.text, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseOperand() (whereNode, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, p.errorf("expected a value")
+	}
+	switch t.kind {
+	case "string", "number":
+		p.pos++
+		return literalNode{value: t.value}, nil
+	case "ident":
+		p.pos++
+		switch t.text {
+		case "true":
+			return literalNode{value: true}, nil
+		case "false":
+			return literalNode{value: false}, nil
+		case "null":
+			return literalNode{value: nil}, nil
+		case "in":
+			return nil, fmt.Errorf("unexpected in at position %d", t.pos)
+		}
+		return pathNode{keys: strings.Split(strings.TrimPrefix(t.text, "."), ".")}, nil
+	case "op":
+		switch t.text {
+		case "(":
+			p.pos++
+			expr, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept("op", ")") {
+				return nil, p.errorf("expected )")
+			}
+			return expr, nil
+		case "[":
+			p.pos++
+			list := listNode{}
+			if p.accept("op", "]") {
+				return list, nil
+			}
+			for {
+				item, err := p.parseOperand()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if p.accept("op", "]") {
+					return list, nil
+				}
+				if !p.accept("op", ",") {
+					return nil, p.errorf("expected , or ]")
+				}
+			}
+		}
+	}
+	return nil, p.errorf("unexpected %s", t.text)
+}
+
+// parseWhere compiles a --where expression into a filter accepting the messages whose JSON document matches it.
+// Messages without JSON never match.
+func parseWhere(expr string) (func(message string) bool, error) {
+	tokens, err := lexWhere(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &whereParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != nil {
+		return nil, p.errorf("unexpected %s", p.peek().text)
+	}
+
+	return func(message string) bool {
+		_, doc, ok := splitJSON(message)
+		if !ok {
+			return false
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(doc), &v); err != nil {
+			return false
+		}
+		return truthy(node.eval(v))
+	}, nil
+}